@@ -12,7 +12,7 @@ import (
 var collectCmd = &cobra.Command{
 	Use:   "collect",
 	Short: "Manage collections of media.",
-	Long:  `The collect command allows you to add, remove, and list details about your media collections stored in ~/.rsdish.`,
+	Long:  `The collect command allows you to add, remove, and list details about your media collections stored in the user config file (see --config).`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// If no subcommand is given, show help for collect.
 		cmd.Help()
@@ -21,13 +21,14 @@ var collectCmd = &cobra.Command{
 
 var collectAddCmd = &cobra.Command{
 	Use:   "add <shortname> <uuid>",
-	Short: "Add a new collection to ~/.rsdish.",
+	Short: "Add a new collection to the user config.",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		shortname := args[0]
 		uuid := args[1]
 
-		cfg, err := persist.LoadConfig() // Use persist.LoadConfig
+		configPath := userConfigPath()
+		cfg, err := persist.LoadConfig(configPath)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 			os.Exit(1)
@@ -44,7 +45,7 @@ var collectAddCmd = &cobra.Command{
 		newCollection := persist.Collection{Short: shortname, UUID: uuid} // Use persist.Collection
 		cfg.Collections = append(cfg.Collections, newCollection)
 
-		if err := persist.SaveConfig(cfg); err != nil { // Use persist.SaveConfig
+		if err := persist.SaveConfig(cfg, configPath); err != nil {
 			fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
 			os.Exit(1)
 		}
@@ -55,12 +56,13 @@ var collectAddCmd = &cobra.Command{
 
 var collectRemoveCmd = &cobra.Command{
 	Use:   "remove <shortname>",
-	Short: "Remove an existing collection from ~/.rsdish.",
+	Short: "Remove an existing collection from the user config.",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		shortname := args[0]
 
-		cfg, err := persist.LoadConfig() // Use persist.LoadConfig
+		configPath := userConfigPath()
+		cfg, err := persist.LoadConfig(configPath)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 			os.Exit(1)
@@ -83,7 +85,7 @@ var collectRemoveCmd = &cobra.Command{
 
 		cfg.Collections = updatedCollections
 
-		if err := persist.SaveConfig(cfg); err != nil { // Use persist.SaveConfig
+		if err := persist.SaveConfig(cfg, configPath); err != nil {
 			fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
 			os.Exit(1)
 		}
@@ -95,12 +97,13 @@ var collectRemoveCmd = &cobra.Command{
 // New collectLsCmd for listing collections
 var collectLsCmd = &cobra.Command{
 	Use:   "ls",
-	Short: "List all collections from ~/.rsdish.",
-	Long:  `The 'ls' subcommand lists all currently defined collections and their UUIDs from the ~/.rsdish configuration file.`,
+	Short: "List all collections from the user config.",
+	Long:  `The 'ls' subcommand lists all currently defined collections and their UUIDs from the user configuration file.`,
 	Args:  cobra.NoArgs, // No arguments expected for 'ls'
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Listing all collections from ~/.rsdish:")
-		cfg, err := persist.LoadConfig() // Use persist.LoadConfig
+		configPath := userConfigPath()
+		fmt.Printf("Listing all collections from %s:\n", configPath)
+		cfg, err := persist.LoadConfig(configPath)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 			os.Exit(1)
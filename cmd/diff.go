@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffBuffers bool // Flag to include buffer volumes in the comparison
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <UUID|shortname>",
+	Short: "Show how the connected volumes of a library differ.",
+	Long: `The diff command walks every connected storage volume of a library and shows,
+for each volume, which files it is missing and which files only it has, followed by
+the files that exist on several volumes with a differing size or modification time.
+
+Nothing is copied or changed. Use it to review what 'sync' would do before
+touching any drive.
+
+Examples:
+  rsdish diff <uuid_or_shortname>
+  rsdish diff <uuid_or_shortname> --buffers`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// 1. Scan the volumes and group them into libraries
+		inv := scanInventory()
+
+		// 2. Resolve Library ID
+		libraryID := args[0]
+		resolvedUUID, err := inv.ResolveLibraryID(libraryID)
+		if err != nil {
+			log.Printf("Warning: Could not resolve '%s': %v. Attempting to use it directly as a UUID.", libraryID, err)
+			resolvedUUID = libraryID // Fallback to using it as is
+		}
+
+		// 3. Compare the volumes
+		diff, err := inv.DiffLibrary(resolvedUUID, diffBuffers)
+		if err != nil {
+			log.Fatalf("Error comparing volumes of library '%s': %v", resolvedUUID, err)
+		}
+
+		fmt.Printf("\n--- Differences in Library %s ---\n", resolvedUUID)
+		for _, volumeDiff := range diff.Volumes {
+			fmt.Printf("Volume: %s (%s)\n", volumeDiff.Volume.BasePath, volumeDiff.Volume.Mode)
+			printPathList("Missing", volumeDiff.Missing)
+			printPathList("Only here", volumeDiff.Unique)
+			fmt.Println("")
+		}
+
+		fmt.Printf("Differing size or modification time (%d):\n", len(diff.Differing))
+		if len(diff.Differing) == 0 {
+			fmt.Println("  (None)")
+		}
+		for _, relPath := range diff.Differing {
+			fmt.Printf("  - %s\n", relPath)
+			for _, volumeDiff := range diff.Volumes {
+				entry, ok := diff.Listings[volumeDiff.Volume][relPath]
+				if !ok {
+					continue
+				}
+				fmt.Printf("      %s: %d bytes, %s\n", volumeDiff.Volume.BasePath, entry.Size,
+					time.Unix(0, entry.ModTime).Format(time.RFC3339))
+			}
+		}
+		fmt.Println("")
+	},
+}
+
+// printPathList displays a titled list of relative paths.
+func printPathList(title string, paths []string) {
+	fmt.Printf("  %s (%d):\n", title, len(paths))
+	if len(paths) == 0 {
+		fmt.Println("    (None)")
+	}
+	for _, relPath := range paths {
+		fmt.Printf("    - %s\n", relPath)
+	}
+}
+
+func init() {
+	diffCmd.Flags().BoolVar(&diffBuffers, "buffers", false, "Also compare buffer volumes, not only storages.")
+}
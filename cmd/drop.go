@@ -3,14 +3,11 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 
 	"rsdish/logi"
 	"rsdish/persist"
-	"rsdish/phys"
 
 	"github.com/spf13/cobra"
 )
@@ -23,7 +20,8 @@ var dropCmd = &cobra.Command{
 	Use:   "drop <Relative FilePath>...",
 	Short: "Generate a script to delete a file from all volumes of a library.",
 	Long: `The drop command generates a safe Rclone script to delete specified files
-from all volumes (buffers and storages) of a given library.
+from all volumes (buffers, storages and partials) of a given library.
+Volumes whose [filter] in volume.toml excludes a path are left alone.
 
 This command does not delete files directly. It generates a script that you
 must review and run manually to perform the deletion. The generated script
@@ -32,89 +30,104 @@ manually remove this flag to execute the actual deletion.
 
 You can specify multiple file paths.
 
+Each dropped path is also recorded as a tombstone in '.rsdish/tombstones.json' on
+every connected volume of the library. A later 'sync' generates the same deletion
+for volumes that were not connected now, and never copies a dropped file back.
+
+With --format json, no script is written; the deletions are printed to stdout
+as a JSON plan instead (see 'rsdish sync --help'). No tombstones are recorded
+then, since nothing says the plan will be carried out; run drop again without
+--format json once the files are deleted.
+
 Examples:
   rsdish drop "photos/2025/vacation.jpg" --from my_photo_archive
   rsdish drop "videos/A.mp4" "videos/B.mp4" --from <UUID>
+  rsdish drop "videos/A.mp4" --from <UUID> --target powershell
 `,
 	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		// 1. Build Physical and Logical Trees
-		phys.BuildPhysTree()
-		logi.BuildLogiTree()
+		// 1. Scan the volumes and group them into libraries
+		inv := scanInventory()
 
 		if dropLibraryID == "" {
 			log.Fatal("Error: The '--from' flag is required to specify the library.")
 		}
-
-		// 2. Resolve Library ID from shortname using persist.LoadConfig
-		resolvedUUID := dropLibraryID // 默认假设输入已经是 UUID
-		cfg, err := persist.LoadConfig()
+		renderer, err := resolveScriptRenderer("")
 		if err != nil {
-			log.Fatalf("Error: Failed to load user config: %v", err)
+			log.Fatalf("Error: %v", err)
 		}
+		checkPlanFormat(true)
 
-		for _, collection := range cfg.Collections {
-			if collection.Short == dropLibraryID {
-				resolvedUUID = collection.UUID
-				log.Printf("Resolved shortname '%s' to UUID '%s'", dropLibraryID, resolvedUUID)
-				break
-			}
+		// 2. Resolve Library ID from shortname or library name
+		resolvedUUID, err := inv.ResolveLibraryID(dropLibraryID)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
 		}
 
 		// 检查解析出的 UUID 是否存在于逻辑树中
-		library, ok := logi.LogiTree[resolvedUUID]
+		library, ok := inv.Libraries[resolvedUUID]
 		if !ok {
-			log.Fatalf("Error: Library with ID '%s' (resolved from '%s') not found in LogiTree. Please check your volume configurations.", resolvedUUID, dropLibraryID)
+			log.Fatalf("Error: Library with ID '%s' (resolved from '%s') not found. Please check your volume configurations.", resolvedUUID, dropLibraryID)
 		}
 
 		// 3. Generate Rclone 'delete' Commands
-		var rcloneCmds strings.Builder
-
-		// Add safety note to the script
-		rcloneCmds.WriteString("# This script will delete files from the following library volumes.\n")
-		rcloneCmds.WriteString("# For safety, it is generated with the '--dry-run' flag.\n")
-		rcloneCmds.WriteString("# To perform the actual deletion, please review this script and remove the '--dry-run' flag.\n\n")
-
-		// Add OS-specific header
-		if runtime.GOOS == "windows" {
-			rcloneCmds.WriteString("@echo off\n\n")
-		} else {
-			rcloneCmds.WriteString("#!/bin/bash\nset -e\n\n")
-		}
-
-		allVolumes := append(library.Buffers, library.Storages...)
+		allVolumes := library.AllVolumes()
 		if len(allVolumes) == 0 {
 			log.Fatalf("Error: Library '%s' has no volumes to drop files from.", resolvedUUID)
 		}
 
-		// Generate a delete command for each file for each volume
+		// Generate a delete operation for each file for each volume
+		var deleteOps []logi.Operation
 		for _, volume := range allVolumes {
 			for _, relativePath := range args {
+				// A file the volume's filter excludes is not part of the library there
+				if !volume.Filter.Match(filepath.ToSlash(relativePath)) {
+					log.Printf("Skipping '%s' on '%s': excluded by the volume's filter.", relativePath, volume.BasePath)
+					continue
+				}
+
 				// Construct the full path to the file to be deleted
 				fullPath := filepath.Join(volume.BasePath, relativePath)
 
 				// Use rclone's delete command. For safety, we use '--dry-run'
-				cmdStr := fmt.Sprintf("rclone delete \"%s\" --dry-run", fullPath)
-				rcloneCmds.WriteString(cmdStr + "\n")
+				deleteOps = append(deleteOps, logi.Operation{
+					Kind:    logi.OpDelete,
+					UUID:    resolvedUUID,
+					Dst:     volume,
+					Files:   []string{filepath.ToSlash(relativePath)},
+					Command: persist.BuildRcloneDeleteCommand(fullPath),
+				})
 			}
-			rcloneCmds.WriteString("\n") // Add a newline between volumes for readability
 		}
 
-		// 4. Write Script to File
-		suffix := ".sh"
-		if runtime.GOOS == "windows" {
-			suffix = ".bat"
+		// 4. Write the plan as JSON to stdout, without recording tombstones: nothing
+		// says the plan is ever carried out
+		if planFormat == "json" {
+			plan := newJSONPlan("drop")
+			plan.addStage(inv, "drop", deleteOps)
+			if err := writeJSONPlan(plan, ""); err != nil {
+				log.Fatalf("Error writing drop plan: %v", err)
+			}
+			return
 		}
-		scriptFileName := fmt.Sprintf("rsdish_drop_%s%s", resolvedUUID[:8], suffix)
 
-		fileMode := os.FileMode(0644)
-		if runtime.GOOS != "windows" {
-			fileMode = 0755
+		// 5. Write Script to File, with a safety note
+		scriptFileName := fmt.Sprintf("rsdish_drop_%s%s", resolvedUUID[:8], renderer.Extension())
+		notes := []string{
+			"This script will delete files from the following library volumes.",
+			"For safety, it is generated with the '--dry-run' flag.",
+			"To perform the actual deletion, please review this script and remove the '--dry-run' flag.",
+		}
+		deleteCmds := logi.Commands(deleteOps)
+		guards := scriptGuards(inv, []string{resolvedUUID}, deleteCmds)
+		if err := generateScript(renderer, scriptFileName, notes, deleteCmds, guards); err != nil {
+			log.Fatalf("Error writing drop script: %v", err)
 		}
 
-		err = os.WriteFile(scriptFileName, []byte(rcloneCmds.String()), fileMode)
-		if err != nil {
-			log.Fatalf("Error writing drop script to file '%s': %v", scriptFileName, err)
+		// 6. Record tombstones so that volumes which are not connected now get the
+		// deletion on a later 'sync', and no sync copies the files back.
+		if err := inv.RecordTombstones(resolvedUUID, args); err != nil {
+			log.Fatalf("Error recording tombstones for library '%s': %v", resolvedUUID, err)
 		}
 
 		fmt.Printf("\nSuccessfully generated deletion script: %s\n", scriptFileName)
@@ -123,7 +136,8 @@ Examples:
 }
 
 func init() {
-	rootCmd.AddCommand(dropCmd)
 	dropCmd.Flags().StringVar(&dropLibraryID, "from", "", "Required: UUID or shortname of the library to delete files from.")
+	dropCmd.Flags().StringVar(&planFormat, "format", "script", "Output format: 'script', or 'json' for a machine-readable plan written to stdout.")
+	dropCmd.Flags().StringVar(&scriptTarget, "target", "", "Optional: Shell to write the script for: "+strings.Join(scriptTargetNames(), ", ")+". Defaults to batch on Windows and bash elsewhere.")
 	dropCmd.MarkFlagRequired("from")
 }
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"rsdish/logi"
+	"rsdish/persist"
+)
+
+// volumeGuard is a pre-flight check written at the top of a generated script: the
+// volume.toml at ConfigPath must still carry LibraryUUID and, if set, VolumeID.
+// It keeps a script from copying into whatever drive is mounted at the same path
+// when it is run later.
+type volumeGuard struct {
+	ConfigPath  string
+	LibraryUUID string
+	VolumeID    string // Empty for legacy volumes without an ID
+}
+
+// scriptGuards returns a guard for every volume of the given libraries that one of the
+// commands touches, i.e. whose base path is an argument or the parent of one.
+func scriptGuards(inv *logi.Inventory, uuids []string, commands []persist.Command) []volumeGuard {
+	var guards []volumeGuard
+	for _, uuid := range uuids {
+		library, ok := inv.Libraries[uuid]
+		if !ok {
+			continue
+		}
+		for _, vol := range library.AllVolumes() {
+			if touchesPath(commands, vol.BasePath) {
+				guards = append(guards, volumeGuard{
+					ConfigPath:  filepath.Join(vol.BasePath, persist.VolumeConfigFileName),
+					LibraryUUID: vol.UUID,
+					VolumeID:    vol.ID,
+				})
+			}
+		}
+	}
+	sort.Slice(guards, func(i, j int) bool {
+		return guards[i].ConfigPath < guards[j].ConfigPath
+	})
+	return guards
+}
+
+// touchesPath reports whether any command argument is basePath or lies beneath it.
+func touchesPath(commands []persist.Command, basePath string) bool {
+	prefix := basePath + string(filepath.Separator)
+	for _, command := range commands {
+		for _, arg := range command.Args {
+			if arg == basePath || strings.HasPrefix(arg, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyLinePattern returns an extended regular expression matching the line of a
+// volume.toml that sets key to value. Matching the whole key line rather than the
+// value alone keeps a guard from passing on a UUID quoted in a note or another key.
+func keyLinePattern(key, value string) string {
+	return `^[[:space:]]*` + key + `[[:space:]]*=[[:space:]]*["']` + regexp.QuoteMeta(value) + `["']`
+}
+
+// findstrKeyLinePattern is keyLinePattern for findstr /R, which knows neither
+// [[:space:]] nor a way to put '"' into a /C: string; any character may quote the value.
+func findstrKeyLinePattern(key, value string) string {
+	var escaped strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(`\.*[]^$`, r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return "^[ \t]*" + key + "[ \t]*=[ \t]*." + escaped.String() + "."
+}
+
+// powerShellKeyLinePattern is keyLinePattern for the .NET regular expressions of -match.
+func powerShellKeyLinePattern(key, value string) string {
+	return `(?m)^\s*` + key + `\s*=\s*["']` + regexp.QuoteMeta(value) + `["']`
+}
+
+// writeUnixGuards adds the guard function and one call per guard to a POSIX shell script.
+// The function takes the config path, the library UUID and the pattern of its key line,
+// then the volume ID and the pattern of its key line.
+func writeUnixGuards(b *strings.Builder, guards []volumeGuard) {
+	b.WriteString("rsdish_check_volume() {\n")
+	b.WriteString("\tif [ ! -f \"$1\" ]; then\n")
+	b.WriteString("\t\techo \"rsdish: $1 not found; is the right drive mounted? Aborting.\" >&2\n")
+	b.WriteString("\t\texit 1\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("\tif ! grep -qE -e \"$3\" \"$1\"; then\n")
+	b.WriteString("\t\techo \"rsdish: $1 does not belong to library $2; another drive may be mounted there. Aborting.\" >&2\n")
+	b.WriteString("\t\texit 1\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("\tif [ -n \"$4\" ] && ! grep -qE -e \"$5\" \"$1\"; then\n")
+	b.WriteString("\t\techo \"rsdish: $1 is not volume $4; another drive may be mounted there. Aborting.\" >&2\n")
+	b.WriteString("\t\texit 1\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("}\n")
+	for _, guard := range guards {
+		fmt.Fprintf(b, "rsdish_check_volume %s %s %s %s %s\n",
+			persist.QuoteArgument(persist.ShellPOSIX, guard.ConfigPath),
+			persist.QuoteArgument(persist.ShellPOSIX, guard.LibraryUUID),
+			persist.QuoteArgument(persist.ShellPOSIX, keyLinePattern("uuid", guard.LibraryUUID)),
+			persist.QuoteArgument(persist.ShellPOSIX, guard.VolumeID),
+			persist.QuoteArgument(persist.ShellPOSIX, keyLinePattern("id", guard.VolumeID)))
+	}
+}
+
+// writeWindowsGuards adds the checks of every guard to a batch script. They are
+// written out per guard rather than as a subroutine, because 'call' would expand
+// the '%' in paths a second time.
+func writeWindowsGuards(b *strings.Builder, guards []volumeGuard) {
+	for _, guard := range guards {
+		configPath := persist.QuoteArgument(persist.ShellCmd, guard.ConfigPath)
+		fmt.Fprintf(b, "if not exist %s (echo rsdish: volume.toml of library %s not found; is the right drive mounted? Aborting. 1>&2 & exit /b 1)\n",
+			configPath, guard.LibraryUUID)
+		fmt.Fprintf(b, "findstr /R /C:\"%s\" %s >nul || (echo rsdish: a volume.toml does not belong to library %s; another drive may be mounted there. Aborting. 1>&2 & exit /b 1)\n",
+			findstrKeyLinePattern("uuid", guard.LibraryUUID), configPath, guard.LibraryUUID)
+		if guard.VolumeID != "" {
+			fmt.Fprintf(b, "findstr /R /C:\"%s\" %s >nul || (echo rsdish: volume %s is not mounted where expected; another drive may be mounted there. Aborting. 1>&2 & exit /b 1)\n",
+				findstrKeyLinePattern("id", guard.VolumeID), configPath, guard.VolumeID)
+		}
+	}
+}
+
+// writePowerShellGuards adds the guard function and one call per guard to a PowerShell
+// script. Its parameters follow those of the POSIX function.
+func writePowerShellGuards(b *strings.Builder, guards []volumeGuard) {
+	b.WriteString("function Test-RsdishVolume([string]$Path, [string]$Library, [string]$LibraryPattern, [string]$Volume, [string]$VolumePattern) {\n")
+	b.WriteString("    if (-not (Test-Path -LiteralPath $Path -PathType Leaf)) {\n")
+	b.WriteString("        [Console]::Error.WriteLine(\"rsdish: $Path not found; is the right drive mounted? Aborting.\")\n")
+	b.WriteString("        exit 1\n")
+	b.WriteString("    }\n")
+	b.WriteString("    $content = Get-Content -LiteralPath $Path -Raw\n")
+	b.WriteString("    if ($content -notmatch $LibraryPattern) {\n")
+	b.WriteString("        [Console]::Error.WriteLine(\"rsdish: $Path does not belong to library $Library; another drive may be mounted there. Aborting.\")\n")
+	b.WriteString("        exit 1\n")
+	b.WriteString("    }\n")
+	b.WriteString("    if ($Volume -and $content -notmatch $VolumePattern) {\n")
+	b.WriteString("        [Console]::Error.WriteLine(\"rsdish: $Path is not volume $Volume; another drive may be mounted there. Aborting.\")\n")
+	b.WriteString("        exit 1\n")
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+	for _, guard := range guards {
+		fmt.Fprintf(b, "Test-RsdishVolume %s %s %s %s %s\n",
+			persist.QuoteArgument(persist.ShellPowerShell, guard.ConfigPath),
+			persist.QuoteArgument(persist.ShellPowerShell, guard.LibraryUUID),
+			persist.QuoteArgument(persist.ShellPowerShell, powerShellKeyLinePattern("uuid", guard.LibraryUUID)),
+			persist.QuoteArgument(persist.ShellPowerShell, guard.VolumeID),
+			persist.QuoteArgument(persist.ShellPowerShell, powerShellKeyLinePattern("id", guard.VolumeID)))
+	}
+}
+
+// writeFishGuards adds the guard function and one call per guard to a fish script.
+// Its arguments follow those of the POSIX function.
+func writeFishGuards(b *strings.Builder, guards []volumeGuard) {
+	b.WriteString("function rsdish_check_volume\n")
+	b.WriteString("    if not test -f \"$argv[1]\"\n")
+	b.WriteString("        echo \"rsdish: $argv[1] not found; is the right drive mounted? Aborting.\" >&2\n")
+	b.WriteString("        exit 1\n")
+	b.WriteString("    end\n")
+	b.WriteString("    if not grep -qE -e \"$argv[3]\" \"$argv[1]\"\n")
+	b.WriteString("        echo \"rsdish: $argv[1] does not belong to library $argv[2]; another drive may be mounted there. Aborting.\" >&2\n")
+	b.WriteString("        exit 1\n")
+	b.WriteString("    end\n")
+	b.WriteString("    if test -n \"$argv[4]\"; and not grep -qE -e \"$argv[5]\" \"$argv[1]\"\n")
+	b.WriteString("        echo \"rsdish: $argv[1] is not volume $argv[4]; another drive may be mounted there. Aborting.\" >&2\n")
+	b.WriteString("        exit 1\n")
+	b.WriteString("    end\n")
+	b.WriteString("end\n")
+	for _, guard := range guards {
+		fmt.Fprintf(b, "rsdish_check_volume %s %s %s %s %s\n",
+			persist.QuoteArgument(persist.ShellFish, guard.ConfigPath),
+			persist.QuoteArgument(persist.ShellFish, guard.LibraryUUID),
+			persist.QuoteArgument(persist.ShellFish, keyLinePattern("uuid", guard.LibraryUUID)),
+			persist.QuoteArgument(persist.ShellFish, guard.VolumeID),
+			persist.QuoteArgument(persist.ShellFish, keyLinePattern("id", guard.VolumeID)))
+	}
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"rsdish/logi"
+	"rsdish/persist"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	libraryName           string // New name of the library
+	libraryDescription    string // New description of the library
+	libraryOwner          string // New owner of the library
+	libraryConflictPolicy string // New conflict policy of the library
+	libraryMinCopies      int    // New replication factor of the library
+)
+
+var libraryCmd = &cobra.Command{
+	Use:   "library",
+	Short: "Show and edit the metadata of libraries.",
+	Long: `The library command manages the metadata shared by all volumes of a library:
+a name, a description, an owner, and the conflict_policy and min_copies policies.
+
+The metadata is stored in '.rsdish/library.toml' on every volume of the library,
+so it travels with the drives. Every change increments a revision counter; when
+volumes disagree, the highest revision wins and is written back onto the others
+by the next 'library set' or 'sync'. A library name can be used wherever a
+library UUID or shortname is accepted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var libraryShowCmd = &cobra.Command{
+	Use:   "show [UUID|shortname|name]",
+	Short: "Show the metadata of one or all connected libraries.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inv := scanInventory()
+
+		var uuids []string
+		if len(args) == 1 {
+			resolvedUUID, err := inv.ResolveLibraryID(args[0])
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			if _, exists := inv.Libraries[resolvedUUID]; !exists {
+				log.Fatalf("Error: Library with ID '%s' (resolved to '%s') not found. Please check your volume configurations.", args[0], resolvedUUID)
+			}
+			uuids = append(uuids, resolvedUUID)
+		} else {
+			uuids = inv.UUIDs()
+		}
+
+		for _, uuid := range uuids {
+			library := inv.Libraries[uuid]
+			fmt.Printf("\nLibrary UUID: %s\n", uuid)
+			printLibraryMeta(library)
+			fmt.Printf("  Conflict policy: %s\n", library.ConflictPolicy())
+			fmt.Printf("  Min copies: %d\n", library.MinCopies())
+		}
+	},
+}
+
+var librarySetCmd = &cobra.Command{
+	Use:   "set <UUID|shortname|name>",
+	Short: "Change the metadata of a library on all its connected volumes.",
+	Long: `Changes the given fields of the library metadata, increments its revision and
+writes it onto every connected volume of the library. Fields that are not given
+keep their current value; pass an empty string to clear a text field, or 0 for
+--min-copies to fall back to volume.toml.
+
+Examples:
+  rsdish library set <uuid_or_shortname> --name "Movies" --owner "alice"
+  rsdish library set Movies --min-copies 3 --conflict-policy newest`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inv := scanInventory()
+
+		resolvedUUID, err := inv.ResolveLibraryID(args[0])
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if _, exists := inv.Libraries[resolvedUUID]; !exists {
+			log.Fatalf("Error: Library with ID '%s' (resolved to '%s') not found. Please check your volume configurations.", args[0], resolvedUUID)
+		}
+
+		flags := cmd.Flags()
+		if !flags.Changed("name") && !flags.Changed("description") && !flags.Changed("owner") &&
+			!flags.Changed("conflict-policy") && !flags.Changed("min-copies") {
+			log.Fatal("Error: Nothing to change. Use --name, --description, --owner, --conflict-policy or --min-copies.")
+		}
+		if flags.Changed("conflict-policy") && libraryConflictPolicy != "" && !persist.IsConflictPolicy(libraryConflictPolicy) {
+			log.Fatalf("Error: Invalid conflict policy '%s'. Must be 'skip', 'newest', 'largest', or 'keep-both'.", libraryConflictPolicy)
+		}
+		if libraryMinCopies < 0 {
+			log.Fatalf("Error: --min-copies must be 0 or more, got %d.", libraryMinCopies)
+		}
+
+		err = inv.UpdateLibraryMeta(resolvedUUID, func(meta *persist.LibraryMeta) {
+			if flags.Changed("name") {
+				meta.Name = libraryName
+			}
+			if flags.Changed("description") {
+				meta.Description = libraryDescription
+			}
+			if flags.Changed("owner") {
+				meta.Owner = libraryOwner
+			}
+			if flags.Changed("conflict-policy") {
+				meta.ConflictPolicy = libraryConflictPolicy
+			}
+			if flags.Changed("min-copies") {
+				meta.MinCopies = libraryMinCopies
+			}
+		})
+		if err != nil {
+			log.Fatalf("Error updating library '%s': %v", resolvedUUID, err)
+		}
+
+		library := inv.Libraries[resolvedUUID]
+		fmt.Printf("Updated library '%s' to revision %d.\n", resolvedUUID, library.Meta.Revision)
+		printLibraryMeta(library)
+	},
+}
+
+// printLibraryMeta displays the metadata of a library as part of 'scan lib' and 'library show'.
+func printLibraryMeta(library *logi.Library) {
+	meta := library.Meta
+	if meta == nil {
+		fmt.Println("  Name: (none, set one with 'rsdish library set')")
+		return
+	}
+
+	name := meta.Name
+	if name == "" {
+		name = "(none)"
+	}
+	fmt.Printf("  Name: %s\n", name)
+	if meta.Description != "" {
+		fmt.Printf("  Description: %s\n", meta.Description)
+	}
+	if meta.Owner != "" {
+		fmt.Printf("  Owner: %s\n", meta.Owner)
+	}
+	fmt.Printf("  Created: %s (revision %d, updated %s)\n",
+		meta.Created.Local().Format(time.DateOnly), meta.Revision, meta.Updated.Local().Format(time.DateTime))
+}
+
+func init() {
+	librarySetCmd.Flags().StringVar(&libraryName, "name", "", "Human-readable name of the library.")
+	librarySetCmd.Flags().StringVar(&libraryDescription, "description", "", "Description of the library.")
+	librarySetCmd.Flags().StringVar(&libraryOwner, "owner", "", "Owner of the library.")
+	librarySetCmd.Flags().StringVar(&libraryConflictPolicy, "conflict-policy", "", "Conflict policy for 'sync': 'skip', 'newest', 'largest', or 'keep-both'. Overrides volume.toml.")
+	librarySetCmd.Flags().IntVar(&libraryMinCopies, "min-copies", 0, "Copies every file should have. Overrides volume.toml.")
+
+	libraryCmd.AddCommand(libraryShowCmd)
+	libraryCmd.AddCommand(librarySetCmd)
+}
@@ -3,9 +3,6 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"rsdish/logi"
-	"rsdish/persist"
-	"rsdish/phys"
 
 	"github.com/spf13/cobra"
 )
@@ -46,9 +43,8 @@ Examples:
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		// 1. Build Physical and Logical Trees
-		phys.BuildPhysTree()
-		logi.BuildLogiTree()
+		// 1. Scan the volumes and group them into libraries
+		inv := scanProbedInventory()
 
 		// 2. Resolve Library ID
 		if len(args) > 0 {
@@ -58,14 +54,14 @@ Examples:
 		resolvedUUID := ""
 		if linkLibraryID != "" {
 			var err error
-			resolvedUUID, err = persist.ResolveCollectionID(linkLibraryID)
+			resolvedUUID, err = inv.ResolveLibraryID(linkLibraryID)
 			if err != nil {
 				log.Printf("Warning: Could not resolve '%s': %v. Attempting to use it directly as a UUID.", linkLibraryID, err)
 				resolvedUUID = linkLibraryID // Fallback to using it as is
 			}
 
-			if _, exists := logi.LogiTree[resolvedUUID]; !exists {
-				log.Fatalf("Error: Library with ID '%s' (resolved to '%s') not found in LogiTree. Please check your volume configurations.", linkLibraryID, resolvedUUID)
+			if _, exists := inv.Libraries[resolvedUUID]; !exists {
+				log.Fatalf("Error: Library with ID '%s' (resolved to '%s') not found. Please check your volume configurations.", linkLibraryID, resolvedUUID)
 			}
 		}
 
@@ -76,7 +72,7 @@ Examples:
 
 		if linkAll {
 			log.Println("Starting link operation for ALL libraries...")
-			err := logi.LinkAllLibrary(linkDryRun)
+			err := inv.LinkAllLibrary(linkDryRun)
 			if err != nil {
 				log.Fatalf("Error during link operation for all libraries: %v", err)
 			}
@@ -85,7 +81,7 @@ Examples:
 				log.Fatal("Error: No library ID specified.")
 			}
 			log.Printf("Starting link operation for library: %s\n", resolvedUUID)
-			err := logi.LinkLibrary(resolvedUUID, linkDryRun)
+			err := inv.LinkLibrary(resolvedUUID, linkDryRun)
 			if err != nil {
 				log.Fatalf("Error during link operation for library '%s': %v", resolvedUUID, err)
 			}
@@ -96,8 +92,6 @@ Examples:
 }
 
 func init() {
-	rootCmd.AddCommand(linkCmd)
-
 	linkCmd.Flags().BoolVar(&linkAll, "all", false, "Process all configured libraries.")
 	linkCmd.Flags().BoolVar(&linkDryRun, "dry-run", false, "Simulate the link creation process without making any changes to the filesystem.")
 }
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	manifestAll  bool // Flag to indicate all libraries should be indexed
+	manifestHash bool // Flag to hash file contents in addition to size and mtime
+)
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest <UUID|shortname>",
+	Short: "Index the files of every connected volume of a library.",
+	Long: `The manifest command walks each connected volume of a library and records every
+file's relative path, size and modification time in '.rsdish/manifest.json' inside
+the volume.
+
+Refreshing is incremental: with --hash, only files whose size or modification time
+changed since the previous manifest are read and hashed again.
+
+Examples:
+  rsdish manifest <uuid_or_shortname>
+  rsdish manifest <uuid_or_shortname> --hash
+  rsdish manifest --all`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("accepts at most one argument, received %d", len(args))
+		}
+		if len(args) == 1 && manifestAll {
+			return fmt.Errorf("cannot use both a library ID and the --all flag")
+		}
+		if len(args) == 0 && !manifestAll {
+			return fmt.Errorf("must specify a library ID or use the --all flag")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// 1. Scan the volumes and group them into libraries
+		inv := scanInventory()
+
+		// 2. Index the requested libraries
+		if manifestAll {
+			if err := inv.RefreshAllManifests(manifestHash); err != nil {
+				log.Fatalf("Error indexing libraries: %v", err)
+			}
+			log.Println("Manifest refresh completed.")
+			return
+		}
+
+		libraryID := args[0]
+		resolvedUUID, err := inv.ResolveLibraryID(libraryID)
+		if err != nil {
+			log.Printf("Warning: Could not resolve '%s': %v. Attempting to use it directly as a UUID.", libraryID, err)
+			resolvedUUID = libraryID // Fallback to using it as is
+		}
+
+		if err := inv.RefreshLibraryManifests(resolvedUUID, manifestHash); err != nil {
+			log.Fatalf("Error indexing library '%s': %v", resolvedUUID, err)
+		}
+		log.Println("Manifest refresh completed.")
+	},
+}
+
+func init() {
+	manifestCmd.Flags().BoolVar(&manifestAll, "all", false, "Index all configured libraries.")
+	manifestCmd.Flags().BoolVar(&manifestHash, "hash", false, "Also record a SHA-256 of each file's content (slow on first run).")
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"rsdish/logi"
+)
+
+// planSchemaVersion is the version of the JSON plan format written by --format json.
+// It is increased whenever a field is removed or changes its meaning; new fields may
+// be added without a new version.
+const planSchemaVersion = 1
+
+var (
+	planFormat string // "script" or "json"
+
+	// messageOut receives the progress messages of plan-producing commands. It is
+	// switched to stderr when the JSON plan itself goes to stdout.
+	messageOut io.Writer = os.Stdout
+)
+
+// jsonPlan is the document written by 'sync --format json' and 'drop --format json'.
+//
+// Schema version 1:
+//
+//	schema_version  always 1
+//	command         "sync" or "drop"
+//	generated       time the plan was made, RFC 3339
+//	stages          in the order they are meant to run: "append", "drain" and
+//	                "storage" for sync, "drop" for drop
+//	  name
+//	  operations    in the order they are meant to run
+//	    type          "copy", "move" or "delete"
+//	    library       library UUID
+//	    library_name  name from the library metadata, if set
+//	    source        volume read from (absent for "move" and "delete")
+//	      id, path, mode
+//	    destination   volume written to, renamed on, or deleted from
+//	      id, path, mode
+//	    files         relative, slash-separated paths the operation is limited to;
+//	                  absent if it covers the whole volume
+//	    rename_to     new relative path of a moved file
+//	    file_list     file passed to rclone --files-from-raw, if any
+//	    bytes         bytes copied or freed; absent if not estimated, so an
+//	                  estimate of 0 is written as 0
+//	    program, args the command to run, as an argument array
+type jsonPlan struct {
+	SchemaVersion int         `json:"schema_version"`
+	Command       string      `json:"command"`
+	Generated     time.Time   `json:"generated"`
+	Stages        []jsonStage `json:"stages"`
+}
+
+type jsonStage struct {
+	Name       string          `json:"name"`
+	Operations []jsonOperation `json:"operations"`
+}
+
+type jsonOperation struct {
+	Type        string      `json:"type"`
+	Library     string      `json:"library"`
+	LibraryName string      `json:"library_name,omitempty"`
+	Source      *jsonVolume `json:"source,omitempty"`
+	Destination *jsonVolume `json:"destination"`
+	Files       []string    `json:"files,omitempty"`
+	RenameTo    string      `json:"rename_to,omitempty"`
+	FileList    string      `json:"file_list,omitempty"`
+	Bytes       *uint64     `json:"bytes,omitempty"` // nil if not estimated, so that 0 is kept
+	Program     string      `json:"program"`
+	Args        []string    `json:"args"`
+}
+
+type jsonVolume struct {
+	ID   string `json:"id,omitempty"` // Empty for legacy volumes without an ID
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+}
+
+// newJSONPlan returns an empty plan of the given command.
+func newJSONPlan(command string) *jsonPlan {
+	return &jsonPlan{
+		SchemaVersion: planSchemaVersion,
+		Command:       command,
+		Generated:     time.Now().UTC(),
+		Stages:        []jsonStage{},
+	}
+}
+
+// addStage appends a stage with the given operations to the plan, naming the libraries from inv.
+func (p *jsonPlan) addStage(inv *logi.Inventory, name string, ops []logi.Operation) {
+	stage := jsonStage{Name: name, Operations: make([]jsonOperation, 0, len(ops))}
+	for _, op := range ops {
+		entry := jsonOperation{
+			Type:        op.Kind,
+			Library:     op.UUID,
+			Source:      newJSONVolume(op.Src),
+			Destination: newJSONVolume(op.Dst),
+			Files:       op.Files,
+			RenameTo:    op.RenameTo,
+			FileList:    op.FileList,
+			Program:     op.Command.Program,
+			Args:        op.Command.Args,
+		}
+		if op.Sized {
+			bytes := op.Bytes
+			entry.Bytes = &bytes
+		}
+		if library, ok := inv.Libraries[op.UUID]; ok && library.Meta != nil {
+			entry.LibraryName = library.Meta.Name
+		}
+		stage.Operations = append(stage.Operations, entry)
+	}
+	p.Stages = append(p.Stages, stage)
+}
+
+func newJSONVolume(vol *logi.Volume) *jsonVolume {
+	if vol == nil {
+		return nil
+	}
+	return &jsonVolume{ID: vol.ID, Path: vol.BasePath, Mode: vol.Mode}
+}
+
+// writeJSONPlan writes the plan to fileName, or to stdout if fileName is empty.
+func writeJSONPlan(plan *jsonPlan, fileName string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	data = append(data, '\n')
+
+	if fileName == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(fileName, data, 0644); err != nil {
+		return fmt.Errorf("failed to write plan '%s': %w", fileName, err)
+	}
+	return nil
+}
+
+// checkPlanFormat exits if --format is neither 'script' nor 'json'. When the JSON plan
+// goes to stdout, progress messages are moved to stderr so they do not mix with it.
+func checkPlanFormat(jsonToStdout bool) {
+	switch planFormat {
+	case "script":
+	case "json":
+		if jsonToStdout {
+			messageOut = os.Stderr
+		}
+	default:
+		log.Fatalf("Error: Invalid format '%s'. Must be 'script' or 'json'.", planFormat)
+	}
+}
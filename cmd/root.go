@@ -2,11 +2,18 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"os"
 
+	"rsdish/logi"
+	"rsdish/persist"
+	"rsdish/phys"
+
 	"github.com/spf13/cobra"
 )
 
+var cfgFile string // Value of --config; empty to use $RSDISH_CONFIG or the default location
+
 var rootCmd = &cobra.Command{
 	Use:   "rsdish",
 	Short: "RSDish is a tool for managing your media libraries.",
@@ -25,9 +32,41 @@ func Execute() {
 	}
 }
 
+// userConfigPath returns the config file selected by --config, $RSDISH_CONFIG or the
+// default location, exiting if none can be determined.
+func userConfigPath() string {
+	configPath, err := persist.ResolveConfigPath(cfgFile)
+	if err != nil {
+		log.Fatalf("Error: Failed to locate user config: %v", err)
+	}
+	return configPath
+}
+
+// loadUserConfig reads the user config, or returns an empty one if it cannot be read.
+func loadUserConfig() *persist.Config {
+	cfg, err := persist.LoadConfig(userConfigPath())
+	if err != nil {
+		log.Printf("Warning: Failed to load user config: %v", err)
+		return &persist.Config{}
+	}
+	return cfg
+}
+
+// scanInventory discovers the volumes of the running system and groups them into libraries.
+func scanInventory() *logi.Inventory {
+	return logi.Scan(phys.NewScanner(loadUserConfig()))
+}
+
+// scanProbedInventory is scanInventory, but also probes the filesystem of every writable
+// volume on disk. Only the commands that report or create links need the measured values.
+func scanProbedInventory() *logi.Inventory {
+	scanner := phys.NewScanner(loadUserConfig())
+	scanner.ProbeFS = true
+	return logi.Scan(scanner)
+}
+
 func init() {
-	// Add global flags here if needed
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.rsdish.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $"+persist.ConfigEnvVar+", then $XDG_CONFIG_HOME/rsdish/config.toml or ~/.config/rsdish/config.toml)")
 
 	// Add subcommands
 	rootCmd.AddCommand(collectCmd)
@@ -36,4 +75,9 @@ func init() {
 	rootCmd.AddCommand(syncCmd)
 	rootCmd.AddCommand(dropCmd)
 	rootCmd.AddCommand(linkCmd)
+	rootCmd.AddCommand(manifestCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(libraryCmd)
+	rootCmd.AddCommand(runCmd)
 }
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"rsdish/logi"
+	"rsdish/persist"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	runOnError string // "stop" or "continue" after a failed command
+	runReport  string // Optional JSON file receiving the per-command results
+)
+
+// commandResult records how one executed command ended.
+type commandResult struct {
+	Command  persist.Command
+	Stage    string        // "append" or "storage"
+	ExitCode int           // Exit status of the process, -1 if it could not be started
+	Err      error         // Why the command failed, nil on success
+	Duration time.Duration // Wall time from start to exit
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Plan and execute the sync commands directly, without writing a script.",
+	Long: `The run command builds the same command lists as 'sync' and executes them
+right away. rclone is started directly with an argument array, not through a
+shell, and its output is streamed to the terminal. Each command's exit status and
+duration are recorded and summarized at the end.
+
+In the combined mode, the 'append' commands run first and the storages are
+planned afterwards, so the plan already sees the files the buffers brought in.
+Planned file lists go to a temporary directory that is removed afterwards.
+
+With --on-error stop (the default) the first failing command ends the run; with
+--on-error continue the remaining commands still run. Either way the exit status
+of rsdish is non-zero if any command failed.
+
+Use 'sync' instead if you want to review the commands before running them.
+Deletions of dropped files carry '--dry-run' here as well; drop and drain
+scripts are only generated by 'drop' and 'sync --drain'.
+
+Examples:
+  rsdish run --library <uuid_or_shortname>
+  rsdish run --mode storage --on-error continue --report run.json`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if runOnError != "stop" && runOnError != "continue" {
+			log.Fatalf("Error: Invalid --on-error policy '%s'. Must be 'stop' or 'continue'.", runOnError)
+		}
+		if syncMode != "" && syncMode != "append" && syncMode != "storage" {
+			log.Fatalf("Error: Invalid sync mode '%s'. Must be 'append', 'storage', or omitted for both.", syncMode)
+		}
+		if syncConflict != "" && !persist.IsConflictPolicy(syncConflict) {
+			log.Fatalf("Error: Invalid conflict policy '%s'. Must be 'skip', 'newest', 'largest', or 'keep-both'.", syncConflict)
+		}
+		if _, err := exec.LookPath("rclone"); err != nil {
+			log.Fatalf("Error: rclone was not found in PATH: %v", err)
+		}
+
+		// 1. Scan the volumes and group them into libraries
+		inv := scanInventory()
+
+		var resolvedUUID string
+		if syncLibraryID != "" {
+			var err error
+			resolvedUUID, err = inv.ResolveLibraryID(syncLibraryID)
+			if err != nil {
+				log.Printf("Warning: Could not resolve '%s': %v. Attempting to use it directly as a UUID.", syncLibraryID, err)
+				resolvedUUID = syncLibraryID
+			}
+			if _, exists := inv.Libraries[resolvedUUID]; !exists {
+				log.Fatalf("Error: Library with ID '%s' (resolved to '%s') not found. Please check your volume configurations.", syncLibraryID, resolvedUUID)
+			}
+		}
+
+		propagateLibraryState(inv, resolvedUUID)
+
+		// 2. Execute the stages in order. The lists rclone reads are kept in a temporary directory.
+		listDir, err := os.MkdirTemp("", "rsdish-run-")
+		if err != nil {
+			log.Fatalf("Error creating a directory for file lists: %v", err)
+		}
+		var results []commandResult
+		stopped := false
+		if syncMode == "" || syncMode == "append" {
+			stageResults, ok := runCommands("append", logi.Commands(buildAppendOps(inv, resolvedUUID, listDir)))
+			results = append(results, stageResults...)
+			stopped = !ok && runOnError == "stop"
+		}
+		if !stopped && (syncMode == "" || syncMode == "storage") {
+			stageResults, _ := runCommands("storage", logi.Commands(buildStorageOps(inv, resolvedUUID, listDir)))
+			results = append(results, stageResults...)
+		}
+		os.RemoveAll(listDir)
+
+		// 3. Report
+		failed := printRunSummary(results)
+		if runReport != "" {
+			if err := writeRunReport(runReport, results); err != nil {
+				log.Printf("Error writing run report: %v", err)
+			} else {
+				fmt.Printf("Wrote run report: %s\n", runReport)
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// runCommands executes the commands of one stage in order, streaming their output.
+// It stops at the first failure if the --on-error policy is 'stop', and reports
+// whether every executed command succeeded.
+func runCommands(stage string, commands []persist.Command) ([]commandResult, bool) {
+	var results []commandResult
+	for i, command := range commands {
+		fmt.Printf("\n[%s %d/%d] %s\n", stage, i+1, len(commands), command.String())
+
+		result := commandResult{Command: command, Stage: stage}
+		process := exec.Command(command.Program, command.Args...)
+		process.Stdout = os.Stdout
+		process.Stderr = os.Stderr
+
+		start := time.Now()
+		err := process.Run()
+		result.Duration = time.Since(start)
+
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			result.ExitCode = 0
+		case errors.As(err, &exitErr):
+			result.ExitCode = exitErr.ExitCode()
+			result.Err = err
+		default:
+			result.ExitCode = -1
+			result.Err = err
+		}
+		results = append(results, result)
+
+		if result.Err != nil {
+			log.Printf("Command failed after %s: %v", result.Duration.Round(time.Millisecond), result.Err)
+			if runOnError == "stop" {
+				log.Printf("Stopping: %d remaining command(s) of stage '%s' were not run.", len(commands)-i-1, stage)
+				return results, false
+			}
+		}
+	}
+	return results, allSucceeded(results)
+}
+
+// allSucceeded reports whether no result carries an error.
+func allSucceeded(results []commandResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// printRunSummary lists every executed command with its status and duration, and
+// returns the number of failed commands.
+func printRunSummary(results []commandResult) int {
+	fmt.Println("\n--- Run Summary ---")
+	if len(results) == 0 {
+		fmt.Println("No commands were run.")
+		return 0
+	}
+
+	failed := 0
+	var total time.Duration
+	for i, result := range results {
+		status := "ok"
+		if result.Err != nil {
+			status = fmt.Sprintf("FAILED (exit %d)", result.ExitCode)
+			failed++
+		}
+		total += result.Duration
+		fmt.Printf("%3d. %-10s %-16s %10s  %s\n", i+1, "["+result.Stage+"]", status, result.Duration.Round(time.Millisecond), result.Command.String())
+	}
+	fmt.Printf("%d command(s) run in %s, %d failed.\n", len(results), total.Round(time.Millisecond), failed)
+	return failed
+}
+
+// writeRunReport writes the results as JSON for later inspection.
+func writeRunReport(path string, results []commandResult) error {
+	type reportEntry struct {
+		Stage      string   `json:"stage"`
+		Program    string   `json:"program"`
+		Args       []string `json:"args"`
+		ExitCode   int      `json:"exit_code"`
+		Error      string   `json:"error,omitempty"`
+		DurationMs int64    `json:"duration_ms"`
+	}
+
+	entries := make([]reportEntry, 0, len(results))
+	for _, result := range results {
+		entry := reportEntry{
+			Stage:      result.Stage,
+			Program:    result.Command.Program,
+			Args:       result.Command.Args,
+			ExitCode:   result.ExitCode,
+			DurationMs: result.Duration.Milliseconds(),
+		}
+		if result.Err != nil {
+			entry.Error = result.Err.Error()
+		}
+		entries = append(entries, entry)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write run report '%s': %w", path, err)
+	}
+	return nil
+}
+
+func init() {
+	runCmd.Flags().StringVarP(&syncMode, "mode", "m", "", "Optional: Sync mode ('append' or 'storage'). If omitted, both are run, 'append' first.")
+	runCmd.Flags().StringVarP(&syncLibraryID, "library", "l", "", "Optional: UUID or shortname of a specific library to sync. If omitted, all libraries will be processed.")
+	runCmd.Flags().BoolVar(&syncMesh, "mesh", false, "Copy every storage onto every other storage instead of planning the missing files.")
+	runCmd.Flags().StringVar(&syncConflict, "conflict", "", "Optional: Conflict policy ('skip', 'newest', 'largest' or 'keep-both'). Defaults to the library's 'conflict_policy', or 'skip'.")
+	runCmd.Flags().BoolVar(&skipSpace, "skip-space-check", false, "Do not walk the volumes to check that destinations have enough free space.")
+	runCmd.Flags().StringVar(&runOnError, "on-error", "stop", "What to do after a failed command: 'stop' or 'continue'.")
+	runCmd.Flags().StringVar(&runReport, "report", "", "Optional: Write the exit status and duration of every command to this JSON file.")
+}
@@ -3,9 +3,10 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"rsdish/logi"    // Import the logi package
-	"rsdish/persist" // Import persist for collection resolution (if needed by other subcommands)
-	"rsdish/phys"    // Import the phys package
+	"strings"
+
+	"rsdish/logi" // Import the logi package
+	"rsdish/phys" // Import the phys package
 
 	"github.com/spf13/cobra"
 )
@@ -25,33 +26,34 @@ var scanLibCmd = &cobra.Command{
 	Long:  `Scans the system for 'volume.toml' files, builds the physical and logical volume trees, and displays the discovered libraries and their associated volumes.`,
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		// 1. Build the Physical Tree first
-		phys.BuildPhysTree()
+		// Discover the volumes and group them into libraries
+		inv := scanProbedInventory()
 
-		// 2. Then, build the Logical Tree from the Physical Tree
-		logi.BuildLogiTree()
+		if len(inv.Phys.TimedOut) > 0 {
+			fmt.Println("\n--- Mount Points and Volumes That Timed Out ---")
+			for _, path := range inv.Phys.TimedOut {
+				fmt.Printf("- %s\n", path)
+			}
+			fmt.Println("Volumes on or at these paths are missing below. Set mount_timeout in the user config to wait longer.")
+		}
 
-		// Display the organized LogiTree
-		if len(logi.LogiTree) == 0 {
+		// Display the organized libraries
+		if len(inv.Libraries) == 0 {
 			fmt.Println("No libraries found. Ensure volume.toml files are correctly placed.")
 			return
 		}
 
 		fmt.Println("\n--- Discovered Libraries and Volumes ---")
-		for uuid, library := range logi.LogiTree {
+		for uuid, library := range inv.Libraries {
 			fmt.Printf("Library UUID: %s\n", uuid)
+			printLibraryMeta(library)
+			printLibraryUsage(library)
 			fmt.Printf("  Buffers (%d):\n", len(library.Buffers))
 			if len(library.Buffers) == 0 {
 				fmt.Println("    (None)")
 			}
 			for _, vol := range library.Buffers {
-				fmt.Printf("    - Path: %s (UUID: %s)\n", vol.BasePath, vol.UUID)
-				if vol.Config != nil && vol.Config.Volume.Note != "" {
-					fmt.Printf("      Note: %s\n", vol.Config.Volume.Note)
-				}
-				if vol.Config != nil && vol.Config.Advanced.RcloneArguments != "" {
-					fmt.Printf("      Rclone Args: \"%s\"\n", vol.Config.Advanced.RcloneArguments)
-				}
+				printVolume(vol)
 			}
 
 			fmt.Printf("  Storages (%d):\n", len(library.Storages))
@@ -59,12 +61,13 @@ var scanLibCmd = &cobra.Command{
 				fmt.Println("    (None)")
 			}
 			for _, vol := range library.Storages {
-				fmt.Printf("    - Path: %s (UUID: %s)\n", vol.BasePath, vol.UUID)
-				if vol.Config != nil && vol.Config.Volume.Note != "" {
-					fmt.Printf("      Note: %s\n", vol.Config.Volume.Note)
-				}
-				if vol.Config != nil && vol.Config.Advanced.RcloneArguments != "" {
-					fmt.Printf("      Rclone Args: \"%s\"\n", vol.Config.Advanced.RcloneArguments)
+				printVolume(vol)
+			}
+
+			if len(library.Partials) > 0 {
+				fmt.Printf("  Partials (%d):\n", len(library.Partials))
+				for _, vol := range library.Partials {
+					printVolume(vol)
 				}
 			}
 			fmt.Println("") // Add a newline for separation
@@ -72,47 +75,156 @@ var scanLibCmd = &cobra.Command{
 	},
 }
 
+// printVolume displays the details of a single volume as part of 'scan lib'.
+func printVolume(vol *logi.Volume) {
+	volumeID := vol.ID
+	if volumeID == "" {
+		volumeID = "none, run 'rsdish template id' in the volume directory"
+	}
+	fmt.Printf("    - Path: %s (ID: %s)\n", vol.BasePath, volumeID)
+	if len(vol.Aliases) > 0 {
+		fmt.Printf("      Also seen at: %s\n", strings.Join(vol.Aliases, ", "))
+	}
+	if vol.Mode == "partial" {
+		budget := "none, limited by free space"
+		if vol.Budget > 0 {
+			budget = phys.FormatBytes(vol.Budget)
+		}
+		fmt.Printf("      Budget: %s\n", budget)
+	}
+	if vol.Config != nil && vol.Config.Volume.Note != "" {
+		fmt.Printf("      Note: %s\n", vol.Config.Volume.Note)
+	}
+	if vol.Config != nil && vol.Config.Advanced.RcloneArguments != "" {
+		fmt.Printf("      Rclone Args: \"%s\"\n", vol.Config.Advanced.RcloneArguments)
+	}
+	if vol.FS != nil {
+		fmt.Printf("      Filesystem: %s\n", describeFSCaps(vol.FS))
+	}
+	if usage, err := phys.GetDiskUsage(vol.BasePath); err == nil {
+		fmt.Printf("      Capacity: %s\n", describeUsage(usage))
+	}
+}
+
+// printLibraryUsage displays the capacity summed over all volumes of a library.
+// Volumes sharing a filesystem are counted once.
+func printLibraryUsage(library *logi.Library) {
+	var total phys.DiskUsage
+	counted := make(map[string]struct{})
+	for _, vol := range library.AllVolumes() {
+		usage, err := phys.GetDiskUsage(vol.BasePath)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+			continue
+		}
+		if usage.Device != "" {
+			if _, seen := counted[usage.Device]; seen {
+				continue
+			}
+			counted[usage.Device] = struct{}{}
+		}
+		total.Total += usage.Total
+		total.Used += usage.Used
+		total.Free += usage.Free
+	}
+	fmt.Printf("  Capacity (all volumes): %s\n", describeUsage(total))
+}
+
+// describeUsage formats total, used and free bytes for display.
+func describeUsage(usage phys.DiskUsage) string {
+	return fmt.Sprintf("%s total, %s used, %s free",
+		phys.FormatBytes(usage.Total), phys.FormatBytes(usage.Used), phys.FormatBytes(usage.Free))
+}
+
+// describeFSCaps formats the filesystem type and capabilities of a volume for display.
+func describeFSCaps(caps *phys.FSCaps) string {
+	fsType := caps.FSType
+	if fsType == "" {
+		fsType = "unknown"
+	}
+	source := "inferred"
+	if caps.Probed {
+		source = "probed"
+	}
+	if caps.ReadOnly {
+		source = "read-only, inferred"
+	}
+
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	return fmt.Sprintf("%s (%s; symlinks: %s, hardlinks: %s, case-sensitive: %s, reflink: %s, max name: %d)",
+		fsType, source, yesNo(caps.Symlinks), yesNo(caps.Hardlinks), yesNo(caps.CaseSensitive), yesNo(caps.Reflink), caps.MaxNameLen)
+}
+
 var scanMpCmd = &cobra.Command{
 	Use:   "mp",
 	Short: "Scan and display system mount points.",
-	Long:  `Scans the system for active mount points and displays them. This can help debug volume discovery issues.`,
-	Args:  cobra.NoArgs,
+	Long: `Scans the system for active mount points and displays them, together with the reason
+for every mount point that volume discovery skips because of exclude_mountpoints,
+include_only_mountpoints or exclude_fs_types in the user config. This can help
+debug volume discovery issues.
+
+Where mount points come from 'df' (macOS, BSD), their filesystem type is unknown
+and exclude_fs_types cannot skip them; such mount points are marked.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		mountPoints, err := phys.GetMountPoints()
+		scanner := phys.NewScanner(loadUserConfig())
+		mounts, err := scanner.ListMounts()
 		if err != nil {
 			log.Fatalf("Error getting mount points: %v", err)
 		}
-
-		cfg, err := persist.LoadConfig()
-		if err != nil {
-			log.Printf("Warning: Could not load user config for additional mount points: %v", err)
-		}
+		candidates := scanner.MountCandidates(mounts)
 
 		fmt.Println("--- Discovered Mount Points ---")
-		if len(mountPoints) == 0 && (cfg == nil || len(cfg.AdditionalMountpoints) == 0) {
+		if len(candidates) == 0 {
 			fmt.Println("No mount points found.")
 			return
 		}
 
-		allMPs := make(map[string]struct{})
-		for _, mp := range mountPoints {
-			allMPs[mp] = struct{}{}
-		}
-		if cfg != nil {
-			for _, amp := range cfg.AdditionalMountpoints {
-				allMPs[amp] = struct{}{}
+		for _, candidate := range candidates {
+			line := "- " + candidate.MountPoint
+			if candidate.Mount != nil {
+				if details := describeMount(*candidate.Mount); details != "" {
+					line += " " + details
+				}
 			}
-		}
-
-		for mp := range allMPs {
-			fmt.Printf("- %s\n", mp)
+			if candidate.Additional {
+				line += " (additional)"
+			}
+			if candidate.SkipReason != "" {
+				line += " [skipped: " + candidate.SkipReason + "]"
+			} else if candidate.Mount != nil && candidate.Mount.FSType == "" && len(scanner.Config.ExcludeFSTypes) > 0 {
+				line += " [filesystem type unknown: exclude_fs_types not applied]"
+			}
+			fmt.Println(line)
 		}
 		fmt.Println("")
 	},
 }
 
+// describeMount formats the filesystem type, source and read-only flag of a mount for display.
+func describeMount(m phys.MountInfo) string {
+	var details []string
+	if m.FSType != "" {
+		details = append(details, m.FSType)
+	}
+	if m.Source != "" {
+		details = append(details, m.Source)
+	}
+	if m.ReadOnly {
+		details = append(details, "ro")
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(details, ", ") + ")"
+}
+
 func init() {
 	scanCmd.AddCommand(scanLibCmd)
 	scanCmd.AddCommand(scanMpCmd)
-	rootCmd.AddCommand(scanCmd)
 }
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"rsdish/persist"
+)
+
+var scriptTarget string // Shell the generated scripts are written for, see scriptRenderers
+
+// scriptRenderer writes a generated script in the language of one shell. Every
+// script-producing command goes through generateScript with one of these.
+type scriptRenderer interface {
+	Extension() string                                    // File extension including the dot, e.g. ".sh"
+	Executable() bool                                     // Whether the script file should get the executable bit
+	WriteHeader(b *strings.Builder)                       // Shebang and settings, written first
+	WriteComment(b *strings.Builder, line string)         // A single comment line
+	WriteGuards(b *strings.Builder, guards []volumeGuard) // Pre-flight checks, written before any command
+	WriteCommand(b *strings.Builder, command persist.Command)
+}
+
+// scriptRenderers maps the values of --target to their renderers.
+var scriptRenderers = map[string]scriptRenderer{
+	"bash":       posixRenderer{interpreter: "/bin/bash"},
+	"sh":         posixRenderer{interpreter: "/bin/sh"},
+	"batch":      batchRenderer{},
+	"powershell": powerShellRenderer{},
+	"fish":       fishRenderer{},
+}
+
+// scriptTargetNames returns the valid values of --target in alphabetical order.
+func scriptTargetNames() []string {
+	names := make([]string, 0, len(scriptRenderers))
+	for name := range scriptRenderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveScriptRenderer returns the renderer selected with --target. Without it, the
+// target follows the extension of outputName if it has a known one, and otherwise the
+// operating system: batch on Windows, bash elsewhere.
+func resolveScriptRenderer(outputName string) (scriptRenderer, error) {
+	if scriptTarget != "" {
+		renderer, ok := scriptRenderers[scriptTarget]
+		if !ok {
+			return nil, fmt.Errorf("invalid script target '%s'. Must be one of: %s", scriptTarget, strings.Join(scriptTargetNames(), ", "))
+		}
+		return renderer, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(outputName)) {
+	case ".sh":
+		return scriptRenderers["bash"], nil
+	case ".bat", ".cmd":
+		return scriptRenderers["batch"], nil
+	case ".ps1":
+		return scriptRenderers["powershell"], nil
+	case ".fish":
+		return scriptRenderers["fish"], nil
+	}
+	if runtime.GOOS == "windows" {
+		return scriptRenderers["batch"], nil
+	}
+	return scriptRenderers["bash"], nil
+}
+
+// generateScript handles writing the script content to a file in the renderer's language.
+// Notes are written as comments below the header, followed by the pre-flight guards.
+func generateScript(renderer scriptRenderer, scriptFileName string, notes []string, commands []persist.Command, guards []volumeGuard) error {
+	var scriptContent strings.Builder
+
+	renderer.WriteHeader(&scriptContent)
+	if len(notes) > 0 {
+		for _, note := range notes {
+			renderer.WriteComment(&scriptContent, note)
+		}
+		scriptContent.WriteString("\n")
+	}
+	if len(guards) > 0 {
+		renderer.WriteComment(&scriptContent, "Pre-flight: abort unless every volume is still the one this script was generated for.")
+		renderer.WriteGuards(&scriptContent, guards)
+		scriptContent.WriteString("\n")
+	}
+
+	for _, command := range commands {
+		renderer.WriteCommand(&scriptContent, command)
+	}
+
+	var fileMode os.FileMode = 0644 // Default to readable
+	if renderer.Executable() {
+		fileMode = 0755
+	}
+
+	err := os.WriteFile(scriptFileName, []byte(scriptContent.String()), fileMode)
+	if err != nil {
+		return fmt.Errorf("error writing Rclone script to file '%s': %w", scriptFileName, err)
+	}
+	return nil
+}
+
+// posixRenderer writes scripts for bash or any POSIX sh, stopping at the first failure.
+type posixRenderer struct {
+	interpreter string // Path in the shebang line
+}
+
+func (r posixRenderer) Extension() string { return ".sh" }
+func (r posixRenderer) Executable() bool  { return true }
+
+func (r posixRenderer) WriteHeader(b *strings.Builder) {
+	b.WriteString("#!" + r.interpreter + "\n")
+	b.WriteString("set -e\n") // Exit on error for shell scripts
+	b.WriteString("\n")
+}
+
+func (r posixRenderer) WriteComment(b *strings.Builder, line string) {
+	b.WriteString("# " + line + "\n")
+}
+
+func (r posixRenderer) WriteGuards(b *strings.Builder, guards []volumeGuard) {
+	writeUnixGuards(b, guards)
+}
+
+func (r posixRenderer) WriteCommand(b *strings.Builder, command persist.Command) {
+	b.WriteString(command.Render(persist.ShellPOSIX) + "\n")
+}
+
+// batchRenderer writes .bat files for cmd.exe.
+type batchRenderer struct{}
+
+func (batchRenderer) Extension() string { return ".bat" }
+func (batchRenderer) Executable() bool  { return false }
+
+func (batchRenderer) WriteHeader(b *strings.Builder) {
+	b.WriteString("@echo off\n")
+	b.WriteString("\n")
+}
+
+func (batchRenderer) WriteComment(b *strings.Builder, line string) {
+	b.WriteString("rem " + line + "\n")
+}
+
+func (batchRenderer) WriteGuards(b *strings.Builder, guards []volumeGuard) {
+	writeWindowsGuards(b, guards)
+}
+
+func (batchRenderer) WriteCommand(b *strings.Builder, command persist.Command) {
+	b.WriteString(command.Render(persist.ShellCmd) + "\n")
+}
+
+// powerShellRenderer writes .ps1 scripts. Native commands do not stop a PowerShell
+// script when they fail, so every command is followed by a check of $LASTEXITCODE.
+type powerShellRenderer struct{}
+
+func (powerShellRenderer) Extension() string { return ".ps1" }
+func (powerShellRenderer) Executable() bool  { return false }
+
+func (powerShellRenderer) WriteHeader(b *strings.Builder) {
+	b.WriteString("$ErrorActionPreference = 'Stop'\n")
+	// Pass arguments with embedded quotes unchanged on PowerShell 7.3 and later
+	b.WriteString("$PSNativeCommandArgumentPassing = 'Standard'\n")
+	b.WriteString("\n")
+}
+
+func (powerShellRenderer) WriteComment(b *strings.Builder, line string) {
+	b.WriteString("# " + line + "\n")
+}
+
+func (powerShellRenderer) WriteGuards(b *strings.Builder, guards []volumeGuard) {
+	writePowerShellGuards(b, guards)
+}
+
+func (powerShellRenderer) WriteCommand(b *strings.Builder, command persist.Command) {
+	b.WriteString("& " + command.Render(persist.ShellPowerShell) + "\n")
+	b.WriteString("if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }\n")
+}
+
+// fishRenderer writes scripts for the fish shell, which has no 'set -e'; every
+// command is followed by 'or exit' instead.
+type fishRenderer struct{}
+
+func (fishRenderer) Extension() string { return ".fish" }
+func (fishRenderer) Executable() bool  { return true }
+
+func (fishRenderer) WriteHeader(b *strings.Builder) {
+	b.WriteString("#!/usr/bin/env fish\n")
+	b.WriteString("\n")
+}
+
+func (fishRenderer) WriteComment(b *strings.Builder, line string) {
+	b.WriteString("# " + line + "\n")
+}
+
+func (fishRenderer) WriteGuards(b *strings.Builder, guards []volumeGuard) {
+	writeFishGuards(b, guards)
+}
+
+func (fishRenderer) WriteCommand(b *strings.Builder, command persist.Command) {
+	b.WriteString(command.Render(persist.ShellFish) + "\n")
+	b.WriteString("or exit $status\n")
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"rsdish/logi"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusAll bool // Flag to indicate all libraries should be reported
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status <UUID|shortname>",
+	Short: "Report files stored on fewer volumes than the library requires.",
+	Long: `The status command counts, for every file of a library, the storage volumes
+holding it, and lists the files below the library's replication factor.
+
+The replication factor is 'min_copies' in the [library] section of volume.toml
+(default 2). Volumes that are not connected are counted from what they held when
+they were last seen by 'status' or 'manifest' on this machine.
+
+Examples:
+  rsdish status <uuid_or_shortname>
+  rsdish status --all`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("accepts at most one argument, received %d", len(args))
+		}
+		if len(args) == 1 && statusAll {
+			return fmt.Errorf("cannot use both a library ID and the --all flag")
+		}
+		if len(args) == 0 && !statusAll {
+			return fmt.Errorf("must specify a library ID or use the --all flag")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// 1. Scan the volumes and group them into libraries
+		inv := scanInventory()
+
+		// 2. Resolve Library ID
+		resolvedUUID := ""
+		if len(args) == 1 {
+			var err error
+			resolvedUUID, err = inv.ResolveLibraryID(args[0])
+			if err != nil {
+				log.Printf("Warning: Could not resolve '%s': %v. Attempting to use it directly as a UUID.", args[0], err)
+				resolvedUUID = args[0] // Fallback to using it as is
+			}
+			if _, exists := inv.Libraries[resolvedUUID]; !exists {
+				log.Fatalf("Error: Library with ID '%s' (resolved to '%s') not found. Please check your volume configurations.", args[0], resolvedUUID)
+			}
+		}
+
+		// 3. Report each library
+		for _, uuid := range targetLibraries(inv, resolvedUUID) {
+			status, err := inv.LibraryReplication(uuid)
+			if err != nil {
+				log.Printf("Error checking library '%s': %v", uuid, err)
+				continue
+			}
+			printStatus(status)
+		}
+	},
+}
+
+// printStatus displays the replication state of a library.
+func printStatus(status *logi.LibraryStatus) {
+	fmt.Printf("\n--- Library %s ---\n", status.UUID)
+	fmt.Printf("Replication factor: %d\n", status.MinCopies)
+	fmt.Printf("Known storage and partial volumes (%d):\n", len(status.Volumes))
+	for _, vol := range status.Volumes {
+		state := "connected"
+		if !vol.Connected {
+			state = "last seen " + vol.LastSeen.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("  - %s (%s)\n", vol.Path, state)
+	}
+
+	fmt.Printf("Files: %d, below replication factor: %d\n", status.Files, len(status.UnderReplicated))
+	for _, file := range status.UnderReplicated {
+		var paths []string
+		for _, holder := range file.Holders {
+			paths = append(paths, holder.Path)
+		}
+		fmt.Printf("  - %s (%d/%d): %s\n", file.Path, len(file.Holders), status.MinCopies, strings.Join(paths, ", "))
+	}
+	fmt.Println("")
+}
+
+func init() {
+	statusCmd.Flags().BoolVar(&statusAll, "all", false, "Report all configured libraries.")
+}
@@ -3,10 +3,9 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"time"
 
 	"rsdish/logi"
 	"rsdish/persist"
@@ -19,52 +18,14 @@ var (
 	syncLibraryID string // UUID or shortname for the library
 	syncMode      string // "append" or "storage" or empty for combined
 	outputFile    string // Optional output file for the script
+	skipSpace     bool   // Skip the free space check on destination volumes
+	syncMesh      bool   // Use full-mesh copies between storages instead of a planned transfer
+	syncConflict  string // Conflict policy overriding the libraries' conflict_policy
+	syncDrain     bool   // Also generate a script emptying buffers of replicated files
+	drainMin      int    // Storages that must hold a buffered file before it is drained (0 = the library's min_copies)
+	drainHash     bool   // Compare SHA-256 in addition to size before draining
 )
 
-// generateScript handles writing the script content to a file, with OS-specific headers.
-func generateScript(scriptFileName string, commands []string) error {
-	var scriptContent strings.Builder
-
-	// Add OS-specific headers
-	switch runtime.GOOS {
-	case "windows":
-		generateWindowsScriptHeader(&scriptContent)
-	default: // Unix-like systems
-		generateUnixScriptHeader(&scriptContent)
-	}
-
-	// Add commands to the script content
-	for _, cmdStr := range commands {
-		scriptContent.WriteString(cmdStr)
-		scriptContent.WriteString("\n")
-	}
-
-	// Set permissions for executable scripts (primarily for Unix-like systems)
-	var fileMode os.FileMode = 0644 // Default to readable
-	if runtime.GOOS != "windows" {
-		fileMode = 0755 // Executable for Unix-like
-	}
-
-	err := os.WriteFile(scriptFileName, []byte(scriptContent.String()), fileMode)
-	if err != nil {
-		return fmt.Errorf("error writing Rclone script to file '%s': %w", scriptFileName, err)
-	}
-	return nil
-}
-
-// generateWindowsScriptHeader adds the appropriate header for a Windows batch script.
-func generateWindowsScriptHeader(b *strings.Builder) {
-	b.WriteString("@echo off\n")
-	b.WriteString("\n")
-}
-
-// generateUnixScriptHeader adds the appropriate header for a Unix-like shell script.
-func generateUnixScriptHeader(b *strings.Builder) {
-	b.WriteString("#!/bin/bash\n")
-	b.WriteString("set -e\n") // Exit on error for shell scripts
-	b.WriteString("\n")
-}
-
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Generate and save Rclone synchronization scripts.",
@@ -74,11 +35,50 @@ You can specify a single library to sync or process all discovered libraries.
 Modes:
   append  : Copies files from buffer volumes to all storage volumes within a library.
             If no library ID is given, performs this for all libraries.
-  storage : Performs bidirectional copies between all storage volumes within a library.
+  storage : Copies to every storage volume the files it is missing from the other storages.
+            The storages are listed first and each missing file is read from a single
+            source; the file lists are written next to the script and passed to
+            'rclone copy --files-from-raw'. With --mesh, bidirectional copies between all
+            storage volumes are generated instead, without listing them.
+            Files held by several storages with a differing size or modification
+            time are conflicts, resolved by the conflict policy: 'skip' (default),
+            'newest', 'largest', or 'keep-both' (the other versions are renamed
+            with a volume suffix). Set it per library with 'conflict_policy' in the
+            [library] section of volume.toml, or for one run with --conflict.
             If no library ID is given, performs this for all libraries.
+            Volumes with mode = "partial" only take part of the library: a file is
+            copied onto one while it has fewer than min_copies copies, on the
+            partial with the most room left in its 'budget' (e.g. "500G").
+            Files dropped with 'rsdish drop' that are still present on a connected
+            volume get an 'rclone delete --dry-run' at the top of the script.
+            With --drain, a separate 'drain' script is generated as well. It deletes
+            from each buffer the files already present with the same size (and,
+            with --verify-hash, the same SHA-256) on as many storage or partial
+            volumes as the library's min_copies requires, or --drain-min-copies
+            of them. Run it after the 'append' script has been run and
+            'sync --drain' has been generated again. Like 'drop', the deletions
+            carry '--dry-run' until you remove it.
   (none)  : If no mode is specified, generates two separate scripts: one for 'append'
             and one for 'storage', each named appropriately.
 
+Apart from the scripts and their file lists, sync writes the merged tombstone journal
+and library metadata into the .rsdish directory of every connected volume of the
+libraries it processes, so that each of them knows about files dropped elsewhere.
+
+Scripts are written for bash, or for batch files on Windows. Use --target to write
+them for another shell: 'bash', 'sh', 'batch', 'powershell' or 'fish'. This also
+lets you prepare a script for a Windows machine on a Linux one.
+
+With --format json, no scripts are written. Instead a single plan is written to
+stdout (or to --output), for use by other tools. Progress messages then go to
+stderr. The plan has "schema_version": 1, and its "stages" (append, drain, storage)
+list "operations". Each operation gives its "type" (copy, move or delete), the
+"library" UUID, the "source" and "destination" volumes with their "id", "path" and
+"mode", and the "program" and "args" to run. It also gives the "files", "file_list"
+and "bytes" where the plan knows them. File lists of planned copies are still
+written next to where the scripts would be, but like 'drop --format json', nothing
+is written onto the volumes: the tombstones and library metadata are left as they are.
+
 Examples:
   rsdish sync --mode append --library <uuid_or_shortname>
   rsdish sync --mode storage --library <uuid_or_shortname>
@@ -86,106 +86,296 @@ Examples:
   rsdish sync --mode storage      (for all libraries)
   rsdish sync                     (generates both append and storage scripts for all libraries)
   rsdish sync --library <uuid_or_shortname> (generates both append and storage for a specific library)
-  rsdish sync --mode append -o my_append_script.bat (or .sh)
+  rsdish sync --mode append -o my_append_script.bat (or .sh, .ps1, .fish)
+  rsdish sync --library <uuid_or_shortname> --target powershell
+  rsdish sync --library <uuid_or_shortname> --format json > plan.json
+  rsdish sync --mode append --drain --drain-min-copies 2
 `,
 	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		// 1. Build Physical and Logical Trees
-		phys.BuildPhysTree()
-		logi.BuildLogiTree()
+		// 1. Scan the volumes and group them into libraries
+		inv := scanInventory()
 
 		var resolvedUUID string
 		if syncLibraryID != "" {
 			var err error
 			// Resolve shortname to UUID if provided
-			resolvedUUID, err = persist.ResolveCollectionID(syncLibraryID)
+			resolvedUUID, err = inv.ResolveLibraryID(syncLibraryID)
 			if err != nil {
 				log.Printf("Warning: Could not resolve '%s': %v. Attempting to use it directly as a UUID.", syncLibraryID, err)
 				resolvedUUID = syncLibraryID // Fallback to using it as is
 			}
 
-			if _, exists := logi.LogiTree[resolvedUUID]; !exists {
-				log.Fatalf("Error: Library with ID '%s' (resolved to '%s') not found in LogiTree. Please check your volume configurations.", syncLibraryID, resolvedUUID)
+			if _, exists := inv.Libraries[resolvedUUID]; !exists {
+				log.Fatalf("Error: Library with ID '%s' (resolved to '%s') not found. Please check your volume configurations.", syncLibraryID, resolvedUUID)
 			}
 		}
 
+		if syncConflict != "" && !persist.IsConflictPolicy(syncConflict) {
+			log.Fatalf("Error: Invalid conflict policy '%s'. Must be 'skip', 'newest', 'largest', or 'keep-both'.", syncConflict)
+		}
+
+		renderer, err := resolveScriptRenderer(outputFile)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		checkPlanFormat(outputFile == "")
+
 		// Determine if combined script is needed
 		generateCombined := syncMode == ""
+		if !generateCombined && syncMode != "append" && syncMode != "storage" {
+			log.Fatalf("Error: Invalid sync mode '%s'. Must be 'append', 'storage', or omitted for combined scripts.", syncMode)
+		}
+
+		if syncDrain && syncMode == "storage" {
+			log.Fatal("Error: --drain empties buffer volumes and can only be used with the 'append' mode or without a mode.")
+		}
+
+		// With --format json, the stages are collected into a single plan instead
+		var plan *jsonPlan
+		if planFormat == "json" {
+			plan = newJSONPlan("sync")
+		}
+
+		// A JSON plan only describes what to do, so the volumes are left untouched
+		if plan == nil {
+			propagateLibraryState(inv, resolvedUUID)
+		}
 
 		// --- Generate Append Commands ---
 		if syncMode == "append" || generateCombined {
-			var appendCmds []string
-			if resolvedUUID != "" {
-				fmt.Printf("Generating 'append' commands for library: %s\n", resolvedUUID)
-				appendCmds = logi.BuildAppend(resolvedUUID)
-			} else {
-				fmt.Println("Generating 'append' commands for all libraries.")
-				appendCmds = logi.BuildAppendAllLibrary()
-			}
+			listDir := scriptListDir(getOutputFileName("append", resolvedUUID, renderer))
+			writeSyncStage(inv, renderer, plan, "append", resolvedUUID, buildAppendOps(inv, resolvedUUID, listDir))
 
-			if len(appendCmds) == 0 {
-				log.Println("No 'append' Rclone commands generated. Check configurations.")
-			} else {
-				appendScriptFileName := getOutputFileName("append", resolvedUUID)
-				err := generateScript(appendScriptFileName, appendCmds)
-				if err != nil {
-					log.Fatalf(err.Error())
-				}
-				fmt.Printf("Successfully generated 'append' script: %s\n", appendScriptFileName)
-			}
-			if !generateCombined { // If only append was requested, we're done
-				return
+			if syncDrain {
+				listDir = scriptListDir(getOutputFileName("drain", resolvedUUID, renderer))
+				writeSyncStage(inv, renderer, plan, "drain", resolvedUUID, buildDrainOps(inv, resolvedUUID, listDir))
 			}
 		}
 
 		// --- Generate Storage Commands ---
 		if syncMode == "storage" || generateCombined {
-			var storageCmds []string
-			if resolvedUUID != "" {
-				fmt.Printf("Generating 'storage' sync commands for library: %s\n", resolvedUUID)
-				storageCmds = logi.BuildSync(resolvedUUID)
-			} else {
-				fmt.Println("Generating 'storage' sync commands for all libraries.")
-				storageCmds = logi.BuildSyncAllLibrary()
+			listDir := scriptListDir(getOutputFileName("storage", resolvedUUID, renderer))
+			writeSyncStage(inv, renderer, plan, "storage", resolvedUUID, buildStorageOps(inv, resolvedUUID, listDir))
+		}
+
+		if plan != nil {
+			if err := writeJSONPlan(plan, outputFile); err != nil {
+				log.Fatal(err)
+			}
+			if outputFile != "" {
+				fmt.Printf("Successfully generated plan: %s\n", outputFile)
 			}
+			return
+		}
+		fmt.Println("\nReview the generated script(s) content before executing.")
+	},
+}
+
+// writeSyncStage writes the operations of one stage of 'sync' into a script, or adds
+// them to plan if the JSON format was chosen.
+func writeSyncStage(inv *logi.Inventory, renderer scriptRenderer, plan *jsonPlan, stage string, resolvedUUID string, ops []logi.Operation) {
+	if plan != nil {
+		plan.addStage(inv, stage, ops)
+		return
+	}
+	if len(ops) == 0 {
+		log.Printf("No '%s' Rclone commands generated. Check configurations.", stage)
+		return
+	}
+
+	scriptFileName := getOutputFileName(stage, resolvedUUID, renderer)
+	cmds := logi.Commands(ops)
+	if err := generateScript(renderer, scriptFileName, nil, cmds, scriptGuards(inv, targetLibraries(inv, resolvedUUID), cmds)); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Successfully generated '%s' script: %s\n", stage, scriptFileName)
+}
+
+// scriptListDir returns the directory holding the file lists of a script: the script's
+// name without its extension, followed by "_files".
+func scriptListDir(scriptFileName string) string {
+	listDir, err := filepath.Abs(strings.TrimSuffix(scriptFileName, filepath.Ext(scriptFileName)) + "_files")
+	if err != nil {
+		log.Fatalf("Error resolving file list directory: %v", err)
+	}
+	return listDir
+}
 
-			if len(storageCmds) == 0 {
-				log.Println("No 'storage' Rclone commands generated. Check configurations.")
-			} else {
-				storageScriptFileName := getOutputFileName("storage", resolvedUUID)
-				err := generateScript(storageScriptFileName, storageCmds)
-				if err != nil {
-					log.Fatalf(err.Error())
-				}
-				fmt.Printf("Successfully generated 'storage' sync script: %s\n", storageScriptFileName)
+// buildDrainOps returns the operations removing from the buffers of the target libraries
+// every file that is already replicated onto the storage volumes. The file lists are
+// written into listDir.
+func buildDrainOps(inv *logi.Inventory, resolvedUUID string, listDir string) []logi.Operation {
+	var plans []*logi.DrainPlan
+	for _, uuid := range targetLibraries(inv, resolvedUUID) {
+		libraryPlans, err := inv.PlanDrain(uuid, drainMin, drainHash)
+		if err != nil {
+			log.Printf("Error planning drain of library '%s': %v", uuid, err)
+			continue
+		}
+		for _, plan := range libraryPlans {
+			fmt.Fprintf(messageOut, "Buffer '%s': %d file(s) replicated (%s can be freed), %d not yet replicated.\n",
+				plan.Buffer.BasePath, len(plan.Files), phys.FormatBytes(plan.Bytes), len(plan.Pending))
+		}
+		plans = append(plans, libraryPlans...)
+	}
+
+	drainOps, err := logi.DrainOperations(plans, listDir)
+	if err != nil {
+		log.Fatalf("Error writing drain file lists: %v", err)
+	}
+	if len(drainOps) == 0 {
+		log.Println("No buffered files are replicated yet.")
+	}
+	return drainOps
+}
+
+// propagateLibraryState brings every connected volume's tombstone journal and library
+// metadata up to date before any copy command refers to them.
+func propagateLibraryState(inv *logi.Inventory, resolvedUUID string) {
+	for _, uuid := range targetLibraries(inv, resolvedUUID) {
+		if err := inv.PropagateTombstones(uuid); err != nil {
+			log.Printf("Error propagating tombstones of library '%s': %v", uuid, err)
+		}
+		if err := inv.PropagateLibraryMeta(uuid); err != nil {
+			log.Printf("Error propagating metadata of library '%s': %v", uuid, err)
+		}
+	}
+}
+
+// buildAppendOps returns the operations copying the buffers of the target libraries
+// onto their storage volumes. The exclude lists are written into listDir.
+func buildAppendOps(inv *logi.Inventory, resolvedUUID string, listDir string) []logi.Operation {
+	var appendOps []logi.Operation
+	if resolvedUUID != "" {
+		fmt.Fprintf(messageOut, "Generating 'append' commands for library: %s\n", resolvedUUID)
+		appendOps = inv.BuildAppend(resolvedUUID, listDir)
+	} else {
+		fmt.Fprintln(messageOut, "Generating 'append' commands for all libraries.")
+		appendOps = inv.BuildAppendAllLibrary(listDir)
+	}
+
+	if len(appendOps) > 0 && !skipSpace {
+		for _, uuid := range targetLibraries(inv, resolvedUUID) {
+			inv.CheckAppendSpace(uuid)
+		}
+	}
+	return appendOps
+}
+
+// buildStorageOps returns the operations synchronizing the storage volumes of the target
+// libraries: the pending deletions of dropped files, then either the planned copies,
+// whose file lists are written into listDir, or with --mesh the full-mesh copies.
+func buildStorageOps(inv *logi.Inventory, resolvedUUID string, listDir string) []logi.Operation {
+	if resolvedUUID != "" {
+		fmt.Fprintf(messageOut, "Generating 'storage' sync commands for library: %s\n", resolvedUUID)
+	} else {
+		fmt.Fprintln(messageOut, "Generating 'storage' sync commands for all libraries.")
+	}
+
+	var storageOps []logi.Operation
+	if syncMesh {
+		if resolvedUUID != "" {
+			storageOps = inv.BuildSync(resolvedUUID, listDir)
+		} else {
+			storageOps = inv.BuildSyncAllLibrary(listDir)
+		}
+		if !skipSpace {
+			for _, uuid := range targetLibraries(inv, resolvedUUID) {
+				inv.CheckSyncSpace(uuid)
 			}
-			if !generateCombined { // If only storage was requested, we're done
-				return
+		}
+	} else {
+		storageOps = buildPlannedStorageOps(inv, resolvedUUID, listDir)
+	}
+
+	// Deletions of dropped files go first, so that nothing is copied from them.
+	var deletionOps []logi.Operation
+	for _, uuid := range targetLibraries(inv, resolvedUUID) {
+		deletionOps = append(deletionOps, inv.PendingDeletions(uuid)...)
+	}
+	if len(deletionOps) > 0 {
+		fmt.Fprintf(messageOut, "Adding %d pending deletion(s) of dropped files (generated with '--dry-run').\n", len(deletionOps))
+		storageOps = append(deletionOps, storageOps...)
+	}
+	return storageOps
+}
+
+// buildPlannedStorageOps plans the storage synchronization of the target libraries and
+// returns 'rclone copy --files-from-raw' operations. The file lists are written into listDir,
+// for scripts a directory named after the script, so the script and its lists can be
+// reviewed together.
+func buildPlannedStorageOps(inv *logi.Inventory, resolvedUUID string, listDir string) []logi.Operation {
+	var plans []*logi.SyncPlan
+	for _, uuid := range targetLibraries(inv, resolvedUUID) {
+		plan, err := inv.PlanSync(uuid, syncConflict)
+		if err != nil {
+			log.Printf("Error planning library '%s': %v", uuid, err)
+			continue
+		}
+		printConflicts(inv, plan)
+		if len(plan.Unplaced) > 0 {
+			log.Printf("Warning: %d file(s) of library '%s' stay below %d copies because no partial volume has room for them.",
+				len(plan.Unplaced), uuid, inv.Libraries[uuid].MinCopies())
+		}
+		if !skipSpace {
+			plan.CheckFreeSpace()
+		}
+		plans = append(plans, plan)
+	}
+
+	ops, err := logi.PlannedSyncOperations(plans, listDir)
+	if err != nil {
+		log.Fatalf("Error writing file lists: %v", err)
+	}
+	return ops
+}
+
+// printConflicts lists the paths whose storages disagree and how the plan resolved them.
+func printConflicts(inv *logi.Inventory, plan *logi.SyncPlan) {
+	if len(plan.Conflicts) == 0 {
+		return
+	}
+
+	fmt.Fprintf(messageOut, "Found %d conflict(s) in library '%s' (policy: %s):\n", len(plan.Conflicts), plan.UUID, plan.Policy)
+	for _, conflict := range plan.Conflicts {
+		resolution := "skipped, every version left in place"
+		if conflict.Winner != nil {
+			resolution = fmt.Sprintf("version from '%s' wins", conflict.Winner.BasePath)
+			if plan.Policy == persist.ConflictKeepBoth {
+				resolution += ", other versions kept under a renamed copy"
 			}
 		}
+		fmt.Fprintf(messageOut, "  - %s: %s\n", conflict.Path, resolution)
 
-		if !generateCombined && syncMode != "append" && syncMode != "storage" {
-			log.Fatalf("Error: Invalid sync mode '%s'. Must be 'append', 'storage', or omitted for combined scripts.", syncMode)
+		for _, vol := range inv.Libraries[plan.UUID].Replicas() {
+			if entry, ok := conflict.Versions[vol]; ok {
+				fmt.Fprintf(messageOut, "      %s: %d bytes, %s\n", vol.BasePath, entry.Size, time.Unix(0, entry.ModTime).Format(time.RFC3339))
+			}
 		}
+	}
+}
 
-		fmt.Println("\nReview the generated script(s) content before executing.")
-	},
+// targetLibraries returns the library UUIDs a command operates on: the resolved one,
+// or every library of the inventory if none was given.
+func targetLibraries(inv *logi.Inventory, resolvedUUID string) []string {
+	if resolvedUUID != "" {
+		return []string{resolvedUUID}
+	}
+	return inv.UUIDs()
 }
 
-// getOutputFileName determines the script filename based on mode, library ID, and OS.
-func getOutputFileName(mode string, libraryUUID string) string {
+// getOutputFileName determines the script filename based on mode, library ID, and the
+// extension of the script target.
+func getOutputFileName(mode string, libraryUUID string, renderer scriptRenderer) string {
 	if outputFile != "" {
 		// If custom output file is provided, use it directly.
 		// Note: User is responsible for extension if -o is used with combined mode.
-		if syncMode == "" { // If combined mode, append mode/library_id to custom name
+		if syncMode == "" || syncMode != mode { // If combined mode or an extra script, append mode/library_id to custom name
 			base := strings.TrimSuffix(outputFile, filepath.Ext(outputFile))
 			ext := filepath.Ext(outputFile)
 			if ext == "" { // Add default extension if none provided with custom name
-				if runtime.GOOS == "windows" {
-					ext = ".bat"
-				} else {
-					ext = ".sh"
-				}
+				ext = renderer.Extension()
 			}
 			if libraryUUID != "" {
 				return fmt.Sprintf("%s_%s_%s%s", base, mode, libraryUUID[:8], ext) // Append short UUID part
@@ -195,11 +385,8 @@ func getOutputFileName(mode string, libraryUUID string) string {
 		return outputFile // If not combined mode, just use the provided output file
 	}
 
-	// Default file name based on mode, library ID, and OS
-	suffix := ".sh"
-	if runtime.GOOS == "windows" {
-		suffix = ".bat"
-	}
+	// Default file name based on mode, library ID, and script target
+	suffix := renderer.Extension()
 
 	name := fmt.Sprintf("rsdish_%s", mode)
 	if libraryUUID != "" {
@@ -209,9 +396,15 @@ func getOutputFileName(mode string, libraryUUID string) string {
 }
 
 func init() {
-	rootCmd.AddCommand(syncCmd)
-
 	syncCmd.Flags().StringVarP(&syncMode, "mode", "m", "", "Optional: Sync mode ('append' or 'storage'). If omitted, both will be generated.")
 	syncCmd.Flags().StringVarP(&syncLibraryID, "library", "l", "", "Optional: UUID or shortname of a specific library to sync. If omitted, all libraries will be processed.")
-	syncCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Optional: Specify the base output file name for the script (e.g., 'my_sync'). Defaults to 'rsdish_[mode]_[uuid_prefix].[sh/bat]'.")
+	syncCmd.Flags().BoolVar(&syncMesh, "mesh", false, "Copy every storage onto every other storage instead of planning the missing files.")
+	syncCmd.Flags().StringVar(&syncConflict, "conflict", "", "Optional: Conflict policy ('skip', 'newest', 'largest' or 'keep-both'). Defaults to the library's 'conflict_policy', or 'skip'.")
+	syncCmd.Flags().BoolVar(&syncDrain, "drain", false, "Also generate a script removing already replicated files from buffer volumes.")
+	syncCmd.Flags().IntVar(&drainMin, "drain-min-copies", 0, "Optional: Storage volumes that must hold a buffered file before it is drained. Defaults to the library's min_copies.")
+	syncCmd.Flags().BoolVar(&drainHash, "verify-hash", false, "Compare SHA-256 hashes, not only sizes, before draining a buffered file.")
+	syncCmd.Flags().BoolVar(&skipSpace, "skip-space-check", false, "Do not walk the volumes to check that destinations have enough free space.")
+	syncCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Optional: Specify the base output file name for the script (e.g., 'my_sync'). Defaults to 'rsdish_[mode]_[uuid_prefix]' with the extension of the script target.")
+	syncCmd.Flags().StringVar(&planFormat, "format", "script", "Output format: 'script', or 'json' for a machine-readable plan written to stdout, or to --output if given.")
+	syncCmd.Flags().StringVar(&scriptTarget, "target", "", "Optional: Shell to write the scripts for: "+strings.Join(scriptTargetNames(), ", ")+". Defaults to the extension of --output, or batch on Windows and bash elsewhere.")
 }
@@ -37,8 +37,9 @@ func GenerateVolumeTemplate(libraryUUID string) *persist.VolumeConfig {
 			UUID: libraryUUID,
 		},
 		Volume: persist.VolumeSection{
-			Mode: "storage", // Default mode
-			Note: "ANY",     // Default note
+			ID:   uuid.New().String(), // Every volume gets its own persistent identity
+			Mode: "storage",           // Default mode
+			Note: "ANY",               // Default note
 		},
 		Advanced: persist.AdvancedSection{ // Reintroduce and populate the advanced section
 			RcloneArguments: "",
@@ -57,7 +58,7 @@ Usage:
 
 Arguments:
   --from <uuid/shortname>  : Optional. Specifies the UUID for the 'library' section.
-                             If a shortname is provided, it will be resolved to its UUID from ~/.rsdish.
+                             If a shortname is provided, it will be resolved to its UUID from the user config.
                              If omitted, a new random UUID will be generated.
   --output <output_path>   : Optional. The path where the volume.toml file will be created.
                              If omitted, 'volume.toml' will be created in the current directory.`,
@@ -77,7 +78,13 @@ Arguments:
 
 		var libraryUUID string
 		if templateFromArg != "" {
-			resolvedUUID, err := persist.ResolveCollectionID(templateFromArg)
+			var err error
+			resolvedUUID := loadUserConfig().ResolveCollectionID(templateFromArg)
+			if _, parseErr := uuid.Parse(resolvedUUID); parseErr != nil {
+				// Not a UUID or shortname; look for a connected library with that name
+				inv := scanInventory()
+				resolvedUUID, err = inv.ResolveLibraryID(templateFromArg)
+			}
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Warning: Could not resolve '%s': %v. Using it directly as UUID.\n", templateFromArg, err)
 				libraryUUID = templateFromArg
@@ -98,9 +105,47 @@ Arguments:
 	},
 }
 
+var templateIDCmd = &cobra.Command{
+	Use:   "id [path/to/volume.toml]",
+	Short: "Assign a volume ID to an existing volume.toml.",
+	Long: `Adds a persistent 'volume.id' to a volume.toml created before volume IDs existed.
+Volumes without an ID can only be told apart by their current path, which changes
+whenever the drive is remounted elsewhere.
+
+If the path is omitted, 'volume.toml' in the current directory is used.
+A volume.toml that already has an ID is left untouched.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tomlPath := "volume.toml"
+		if len(args) == 1 {
+			tomlPath = args[0]
+		}
+
+		volumeCfg, err := persist.LoadVolumeConfig(tomlPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading volume config: %v\n", err)
+			os.Exit(1)
+		}
+
+		if volumeCfg.Volume.ID != "" {
+			fmt.Printf("Volume at '%s' already has ID '%s'.\n", tomlPath, volumeCfg.Volume.ID)
+			return
+		}
+
+		volumeCfg.Volume.ID = uuid.New().String()
+		if err := persist.SaveTomlConfig(volumeCfg, tomlPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving volume config: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Assigned volume ID '%s' to '%s'.\n", volumeCfg.Volume.ID, tomlPath)
+	},
+}
+
 func init() {
 	templateCmd.AddCommand(templateNewCmd)
+	templateCmd.AddCommand(templateIDCmd)
 
-	templateNewCmd.Flags().StringVarP(&templateFromArg, "from", "f", "", "Optional: Specify UUID or shortname for the 'library' section. If a shortname is given, it will be resolved to its UUID from ~/.rsdish.")
+	templateNewCmd.Flags().StringVarP(&templateFromArg, "from", "f", "", "Optional: Specify UUID or shortname for the 'library' section. If a shortname is given, it will be resolved to its UUID from the user config.")
 	templateNewCmd.Flags().StringVarP(&templateOutputArg, "output", "o", "", "Optional: Path where the volume.toml file will be created. Defaults to 'volume.toml' in the current directory.")
 }
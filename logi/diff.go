@@ -0,0 +1,137 @@
+package logi
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"rsdish/persist"
+	"rsdish/phys"
+)
+
+// ModifyWindow is the largest modification time difference still treated as equal.
+// FAT filesystems store mtimes with a two second resolution.
+const ModifyWindow = 2 * time.Second
+
+// VolumeDiff lists how one volume differs from the other volumes of its library.
+type VolumeDiff struct {
+	Volume  *Volume
+	Missing []string // Present on another compared volume but not on this one
+	Unique  []string // Present on this volume only
+}
+
+// LibraryDiff is the result of comparing the connected volumes of a library.
+type LibraryDiff struct {
+	UUID      string
+	Volumes   []VolumeDiff
+	Differing []string                                     // Present on several volumes with differing size or mtime
+	Listings  map[*Volume]map[string]persist.ManifestEntry // File listing of each compared volume
+}
+
+// ListVolume walks a volume through fsys and returns its current file listing, keyed
+// by relative path.
+func ListVolume(fsys phys.FileSystem, vol *Volume) (map[string]persist.ManifestEntry, error) {
+	listing, _, err := persist.ScanManifest(fsys, vol.BasePath, nil, false, vol.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return listing.Entries, nil
+}
+
+// SameEntry reports whether two manifest entries describe the same file content,
+// judged by size and modification time (within ModifyWindow), or by hash when both have one.
+func SameEntry(a, b persist.ManifestEntry) bool {
+	if a.Size != b.Size {
+		return false
+	}
+	if a.SHA256 != "" && b.SHA256 != "" {
+		return a.SHA256 == b.SHA256
+	}
+	delta := time.Duration(a.ModTime - b.ModTime)
+	if delta < 0 {
+		delta = -delta
+	}
+	return delta <= ModifyWindow
+}
+
+// DiffLibrary walks the storage and partial volumes of a library (and its buffers, if
+// includeBuffers is set) and compares their contents. Partial volumes only hold part
+// of the library, so nothing is reported missing from them, and nothing is reported
+// missing from a volume whose filter excludes it.
+func (inv *Inventory) DiffLibrary(uuid string, includeBuffers bool) (*LibraryDiff, error) {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return nil, fmt.Errorf("library with UUID '%s' not found", uuid)
+	}
+
+	volumes := library.Replicas()
+	if includeBuffers {
+		volumes = append(volumes, library.Buffers...)
+	}
+	if len(volumes) < 2 {
+		return nil, fmt.Errorf("library '%s' needs at least 2 connected volumes to compare", uuid)
+	}
+
+	diff := &LibraryDiff{
+		UUID:     uuid,
+		Listings: make(map[*Volume]map[string]persist.ManifestEntry),
+	}
+	union := make(map[string]struct{})
+	for _, vol := range volumes {
+		listing, err := ListVolume(inv.FileSystem, vol)
+		if err != nil {
+			return nil, err
+		}
+		diff.Listings[vol] = listing
+		for relPath := range listing {
+			union[relPath] = struct{}{}
+		}
+	}
+
+	for _, vol := range volumes {
+		volumeDiff := VolumeDiff{Volume: vol}
+		for relPath := range union {
+			if _, ok := diff.Listings[vol][relPath]; !ok {
+				if vol.Mode == "partial" || !vol.Filter.Match(relPath) {
+					continue
+				}
+				volumeDiff.Missing = append(volumeDiff.Missing, relPath)
+			} else if holders(diff.Listings, relPath) == 1 {
+				volumeDiff.Unique = append(volumeDiff.Unique, relPath)
+			}
+		}
+		sort.Strings(volumeDiff.Missing)
+		sort.Strings(volumeDiff.Unique)
+		diff.Volumes = append(diff.Volumes, volumeDiff)
+	}
+
+	for relPath := range union {
+		var first *persist.ManifestEntry
+		for _, vol := range volumes {
+			entry, ok := diff.Listings[vol][relPath]
+			if !ok {
+				continue
+			}
+			if first == nil {
+				first = &entry
+			} else if !SameEntry(*first, entry) {
+				diff.Differing = append(diff.Differing, relPath)
+				break
+			}
+		}
+	}
+	sort.Strings(diff.Differing)
+
+	return diff, nil
+}
+
+// holders counts the listings that contain relPath.
+func holders(listings map[*Volume]map[string]persist.ManifestEntry, relPath string) int {
+	n := 0
+	for _, listing := range listings {
+		if _, ok := listing[relPath]; ok {
+			n++
+		}
+	}
+	return n
+}
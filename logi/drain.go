@@ -0,0 +1,139 @@
+package logi
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+
+	"rsdish/persist"
+	"rsdish/phys"
+)
+
+// DrainPlan lists the files of a buffer volume that are safely replicated onto the
+// storage volumes and can therefore be removed from the buffer.
+type DrainPlan struct {
+	UUID    string
+	Buffer  *Volume
+	Files   []string // Replicated often enough; removed from the buffer
+	Pending []string // Not yet replicated often enough; kept on the buffer
+	Bytes   uint64   // Bytes freed on the buffer by removing Files
+}
+
+// PlanDrain checks every file of every buffer of a library against the connected
+// storage and partial volumes. A file counts as replicated on a volume if the volume
+// holds the same relative path with the same size, and, if verifyHash is set, the same
+// SHA-256. A file is drained once it is replicated on minCopies volumes, or on the
+// library's min_copies volumes if minCopies is 0.
+func (inv *Inventory) PlanDrain(uuid string, minCopies int, verifyHash bool) ([]*DrainPlan, error) {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return nil, fmt.Errorf("library with UUID '%s' not found", uuid)
+	}
+	replicas := library.Replicas()
+	if len(replicas) == 0 {
+		log.Printf("Library '%s' has no connected storage or partial volumes. Nothing can be drained.", uuid)
+		return nil, nil
+	}
+
+	required := minCopies
+	if required <= 0 {
+		required = library.MinCopies()
+	}
+	// A file is never drained without a copy, even if only partial volumes are connected
+	if required < 1 {
+		required = 1
+	}
+	if required > len(replicas) {
+		log.Printf("Warning: Library '%s' requires %d copies before draining, but only %d storage or partial volumes are connected. Nothing will be drained.", uuid, required, len(replicas))
+	}
+
+	var plans []*DrainPlan
+	for _, buffer := range library.Buffers {
+		listing, err := ListVolume(inv.FileSystem, buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		paths := make([]string, 0, len(listing))
+		for relPath := range listing {
+			paths = append(paths, relPath)
+		}
+		sort.Strings(paths)
+
+		plan := &DrainPlan{UUID: uuid, Buffer: buffer}
+		for _, relPath := range paths {
+			entry := listing[relPath]
+			copies, err := countReplicas(inv.FileSystem, buffer, relPath, entry, replicas, verifyHash)
+			if err != nil {
+				return nil, err
+			}
+			if copies >= required {
+				plan.Files = append(plan.Files, relPath)
+				plan.Bytes += uint64(entry.Size)
+			} else {
+				plan.Pending = append(plan.Pending, relPath)
+			}
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// countReplicas counts the storage and partial volumes holding the same version of a buffered file.
+func countReplicas(fsys phys.FileSystem, buffer *Volume, relPath string, entry persist.ManifestEntry, storages []*Volume, verifyHash bool) (int, error) {
+	bufferHash := ""
+	copies := 0
+	for _, storage := range storages {
+		storagePath := filepath.Join(storage.BasePath, filepath.FromSlash(relPath))
+		info, err := fsys.Stat(storagePath)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != entry.Size {
+			continue
+		}
+
+		if verifyHash {
+			if bufferHash == "" {
+				bufferHash, err = persist.HashFile(fsys, filepath.Join(buffer.BasePath, filepath.FromSlash(relPath)))
+				if err != nil {
+					return 0, err
+				}
+			}
+			storageHash, err := persist.HashFile(fsys, storagePath)
+			if err != nil {
+				log.Printf("Warning: %v", err)
+				continue
+			}
+			if storageHash != bufferHash {
+				continue
+			}
+		}
+		copies++
+	}
+	return copies, nil
+}
+
+// DrainOperations writes the list of drained files of each buffer into listDir and
+// returns one 'rclone delete --files-from-raw' operation per buffer.
+func DrainOperations(plans []*DrainPlan, listDir string) ([]Operation, error) {
+	var ops []Operation
+	for _, plan := range plans {
+		if len(plan.Files) == 0 {
+			continue
+		}
+		listPath := filepath.Join(listDir, fmt.Sprintf("drain_%s.txt", shortKey(plan.Buffer)))
+		if err := persist.WriteFileList(listPath, plan.Files); err != nil {
+			return nil, err
+		}
+		ops = append(ops, Operation{
+			Kind:     OpDelete,
+			UUID:     plan.UUID,
+			Dst:      plan.Buffer,
+			Files:    plan.Files,
+			FileList: listPath,
+			Bytes:    plan.Bytes,
+			Sized:    true,
+			Command:  persist.BuildRcloneDeleteListCommand(plan.Buffer.BasePath, listPath),
+		})
+	}
+	return ops, nil
+}
@@ -0,0 +1,125 @@
+package logi
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"rsdish/persist"
+	"rsdish/phys"
+)
+
+// loadLibraryMeta returns the most recent library metadata found on the connected
+// volumes of a library, read through fsys, or nil if none of them has any.
+func loadLibraryMeta(fsys phys.FileSystem, library *Library) *persist.LibraryMeta {
+	var newest *persist.LibraryMeta
+	for _, vol := range library.AllVolumes() {
+		meta, err := persist.LoadLibraryMeta(fsys, vol.BasePath)
+		if err != nil {
+			log.Printf("Warning: Ignoring unreadable library metadata of '%s': %v", vol.BasePath, err)
+			continue
+		}
+		if meta == nil {
+			continue
+		}
+		if meta.UUID != library.UUID {
+			log.Printf("Warning: Ignoring library metadata of '%s': it belongs to library '%s'.", vol.BasePath, meta.UUID)
+			continue
+		}
+
+		if newest != nil && meta.Revision == newest.Revision && !meta.Equal(newest) {
+			log.Printf("Warning: Volumes of library '%s' hold different metadata with the same revision %d; keeping the most recently updated one.", library.UUID, meta.Revision)
+		}
+		if meta.Newer(newest) {
+			newest = meta
+		}
+	}
+	return newest
+}
+
+// Name returns the human-readable name of the library, or an empty string if it has none.
+func (l *Library) Name() string {
+	if l.Meta == nil {
+		return ""
+	}
+	return l.Meta.Name
+}
+
+// UpdateLibraryMeta applies change to the metadata of a library, increments its
+// revision and writes it onto every connected volume.
+func (inv *Inventory) UpdateLibraryMeta(uuid string, change func(meta *persist.LibraryMeta)) error {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return fmt.Errorf("library with UUID '%s' not found", uuid)
+	}
+
+	meta := persist.NewLibraryMeta(uuid)
+	if library.Meta != nil {
+		copied := *library.Meta
+		meta = &copied
+	}
+	change(meta)
+	meta.Revision++
+	meta.Updated = time.Now().UTC()
+	library.Meta = meta
+
+	return inv.PropagateLibraryMeta(uuid)
+}
+
+// PropagateLibraryMeta writes the current metadata of a library onto every connected
+// volume whose copy is missing or outdated.
+func (inv *Inventory) PropagateLibraryMeta(uuid string) error {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return fmt.Errorf("library with UUID '%s' not found", uuid)
+	}
+	if library.Meta == nil {
+		return nil
+	}
+
+	for _, vol := range library.AllVolumes() {
+		if vol.FS != nil && vol.FS.ReadOnly {
+			continue
+		}
+		meta, err := persist.LoadLibraryMeta(inv.FileSystem, vol.BasePath)
+		if err == nil && library.Meta.Equal(meta) {
+			continue // Already up to date
+		}
+		if err := persist.SaveLibraryMeta(vol.BasePath, library.Meta); err != nil {
+			log.Printf("Error writing library metadata to '%s': %v", vol.BasePath, err)
+			continue
+		}
+		log.Printf("Updated library metadata on '%s' (revision %d).", vol.BasePath, library.Meta.Revision)
+	}
+	return nil
+}
+
+// ResolveLibraryID takes an ID (shortname, UUID or library name) and returns the
+// corresponding UUID. Shortnames from the user config are tried first, then the names
+// of the libraries in the inventory, compared case-insensitively. Unknown IDs are returned
+// as they are, so that callers report them as not found.
+func (inv *Inventory) ResolveLibraryID(id string) (string, error) {
+	resolvedUUID := inv.Config.ResolveCollectionID(id)
+	if _, ok := inv.Libraries[resolvedUUID]; ok {
+		return resolvedUUID, nil
+	}
+
+	var matches []string
+	for uuid, library := range inv.Libraries {
+		if name := library.Name(); name != "" && strings.EqualFold(name, id) {
+			matches = append(matches, uuid)
+		}
+	}
+	sort.Strings(matches)
+	switch len(matches) {
+	case 0:
+		return resolvedUUID, nil
+	case 1:
+		log.Printf("Resolved library name '%s' to UUID '%s'", id, matches[0])
+		return matches[0], nil
+	default:
+		return id, fmt.Errorf("library name '%s' is ambiguous, it matches %s", id, strings.Join(matches, ", "))
+	}
+}
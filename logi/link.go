@@ -9,8 +9,8 @@ import (
 // LinkLibrary orchestrates the linking process for a single library.
 // 它只处理存储卷之间的链接，根据目标卷的配置来决定链接类型。
 // 如果 dryRun 为 true，它只会打印操作而不执行。
-func LinkLibrary(uuid string, dryRun bool) error {
-	library, ok := LogiTree[uuid]
+func (inv *Inventory) LinkLibrary(uuid string, dryRun bool) error {
+	library, ok := inv.Libraries[uuid]
 	if !ok {
 		return fmt.Errorf("library with UUID '%s' not found", uuid)
 	}
@@ -34,11 +34,16 @@ func LinkLibrary(uuid string, dryRun bool) error {
 				continue
 			}
 
+			if reason := linkUnsupported(dstVol, linkCreateMode); reason != "" {
+				log.Printf("Refusing to link from '%s' to '%s' (mode: '%s'): %s.", srcVol.BasePath, dstVol.BasePath, linkCreateMode, reason)
+				continue
+			}
+
 			if dryRun {
 				log.Printf("[DRY RUN] Would create links from '%s' to '%s' (mode: '%s')", srcVol.BasePath, dstVol.BasePath, linkCreateMode)
 			} else {
 				log.Printf("Creating links from '%s' to '%s' (mode: '%s')...", srcVol.BasePath, dstVol.BasePath, linkCreateMode)
-				err := persist.LinkAll(srcVol.BasePath, dstVol.BasePath, linkCreateMode)
+				err := persist.LinkAll(srcVol.BasePath, dstVol.BasePath, linkCreateMode, srcVol.Filter, dstVol.Filter)
 				if err != nil {
 					log.Printf("Error creating links: %v", err)
 				}
@@ -49,15 +54,30 @@ func LinkLibrary(uuid string, dryRun bool) error {
 	return nil
 }
 
-// LinkAllLibrary iterates through all libraries in LogiTree and calls LinkLibrary for each.
+// linkUnsupported returns why the destination volume's filesystem cannot hold links
+// of the given mode, or an empty string if it can (or its capabilities are unknown).
+func linkUnsupported(dstVol *Volume, mode string) string {
+	if dstVol.FS == nil {
+		return ""
+	}
+	if dstVol.FS.ReadOnly {
+		return "the destination is mounted read-only"
+	}
+	if mode == "symlink" && !dstVol.FS.Symlinks {
+		return fmt.Sprintf("the destination filesystem (%s) does not support symlinks", dstVol.FS.FSType)
+	}
+	return ""
+}
+
+// LinkAllLibrary iterates through all libraries in the inventory and calls LinkLibrary for each.
 // 如果 dryRun 为 true，它只会打印操作而不执行。
-func LinkAllLibrary(dryRun bool) error {
-	if len(LogiTree) == 0 {
+func (inv *Inventory) LinkAllLibrary(dryRun bool) error {
+	if len(inv.Libraries) == 0 {
 		return fmt.Errorf("no libraries found to link")
 	}
 
-	for uuid := range LogiTree {
-		err := LinkLibrary(uuid, dryRun)
+	for uuid := range inv.Libraries {
+		err := inv.LinkLibrary(uuid, dryRun)
 		if err != nil {
 			log.Printf("Error processing library '%s': %v", uuid, err)
 		}
@@ -2,82 +2,196 @@ package logi
 
 import (
 	"log"
+	"sort"
+
 	"rsdish/persist" // To access persist.VolumeConfig
-	"rsdish/phys"    // To access phys.PhysTree
+	"rsdish/phys"    // To access phys.Snapshot
 )
 
-// LogiTree is a placeholder for the logical representation of all libraries and volumes.
-// This would be populated by a function like `BuildLogiTree` which processes `phys.PhysTree`.
-var LogiTree = make(map[string]*Library)
+// Inventory is the logical view of one scan: the discovered volumes grouped into
+// libraries. Commands build one with Scan and pass it to everything they call.
+// Apart from the library metadata and tombstones, which commands write back onto
+// the volumes, it is not changed after it was built.
+type Inventory struct {
+	Config    *persist.Config     // User config the scan was made with
+	Phys      *phys.Snapshot      // Physical volumes the libraries were built from
+	Libraries map[string]*Library // Library UUID to library
+
+	// FileSystem is what the files of the volumes are walked, read and hashed through:
+	// the scanner's filesystem, or the operating system's for a snapshot built elsewhere.
+	FileSystem phys.FileSystem
+}
 
 // Library represents a logical collection of volumes.
 type Library struct {
-	UUID     string
-	Buffers  []*Volume // Volumes with mode="buffer"
-	Storages []*Volume // Volumes with mode="storage"
+	UUID       string
+	Buffers    []*Volume                 // Volumes with mode="buffer"
+	Storages   []*Volume                 // Volumes with mode="storage", each holding the whole library
+	Partials   []*Volume                 // Volumes with mode="partial", holding files only up to their budget
+	Tombstones *persist.TombstoneJournal // Files dropped from the library, merged from all connected volumes
+	Meta       *persist.LibraryMeta      // Name, owner and policies of the library (nil if never set)
+}
+
+// ConflictPolicy returns the conflict policy set in the library metadata, or else the
+// one configured in the volume.toml files of the library, or "skip" if none is. If
+// volumes disagree, the first one (by path) wins.
+func (l *Library) ConflictPolicy() string {
+	if l.Meta != nil && l.Meta.ConflictPolicy != "" {
+		return l.Meta.ConflictPolicy
+	}
+
+	policy := ""
+	for _, vol := range l.AllVolumes() {
+		p := vol.Config.Library.ConflictPolicy
+		if p == "" {
+			continue
+		}
+		if policy == "" {
+			policy = p
+		} else if p != policy {
+			log.Printf("Warning: Volume '%s' sets conflict_policy '%s', but library '%s' already uses '%s'.", vol.BasePath, p, l.UUID, policy)
+		}
+	}
+	if policy == "" {
+		return persist.ConflictSkip
+	}
+	return policy
+}
+
+// AllVolumes returns the buffer, storage and partial volumes of the library.
+func (l *Library) AllVolumes() []*Volume {
+	return append(append(append([]*Volume{}, l.Buffers...), l.Storages...), l.Partials...)
+}
+
+// Replicas returns the volumes that count as copies of a file: storages and partials.
+func (l *Library) Replicas() []*Volume {
+	return append(append([]*Volume{}, l.Storages...), l.Partials...)
 }
 
 // Volume represents a single logical volume, derived from a physical volume.
 type Volume struct {
-	UUID     string
+	UUID     string // UUID of the library this volume belongs to
+	ID       string // Persistent UUID of the volume itself (empty for legacy volume.toml files)
 	Mode     string
+	Budget   uint64          // Size limit of a partial volume in bytes (0 = limited by free space only)
+	Filter   *persist.Filter // Files that belong on this volume (nil = every file)
 	BasePath string
+	Aliases  []string              // Other paths under which the same volume directory was discovered
+	FS       *phys.FSCaps          // Capabilities of the filesystem holding the volume (nil if unknown)
 	Config   *persist.VolumeConfig // Stores the full parsed volume.toml config
 }
 
-// BuildLogiTree processes the physical volume tree (phys.PhysTree)
-// and constructs the logical library tree (LogiTree).
-// It groups volumes by their library UUID and categorizes them as buffers or storages.
-//
-// NOTE: phys.PhysTree is assumed to be read-only after its generation and
-// is regenerated on each command execution, thus no explicit locking is required here.
-func BuildLogiTree() {
-	// Clear the LogiTree before rebuilding to ensure a fresh state
-	LogiTree = make(map[string]*Library)
+// Key returns a stable identifier for tracking the volume over time.
+// It is the volume ID when one is configured, and falls back to the current
+// BasePath for legacy volumes, which is only stable until the drive is remounted.
+func (v *Volume) Key() string {
+	if v.ID != "" {
+		return v.ID
+	}
+	return v.BasePath
+}
+
+// Scan discovers the volumes with scanner and builds an Inventory from them.
+func Scan(scanner *phys.Scanner) *Inventory {
+	return NewInventory(scanner.Config, scanner.Scan(), scanner.FS)
+}
+
+// NewInventory processes the physical volumes of a snapshot and constructs the
+// logical libraries. It groups volumes by their library UUID and categorizes them
+// as buffers, storages or partials. The files of the volumes are read through fsys,
+// or through the operating system if fsys is nil.
+func NewInventory(cfg *persist.Config, snapshot *phys.Snapshot, fsys phys.FileSystem) *Inventory {
+	if cfg == nil {
+		cfg = &persist.Config{}
+	}
+	if fsys == nil {
+		fsys = phys.OSFileSystem{}
+	}
+	inv := &Inventory{Config: cfg, Phys: snapshot, Libraries: make(map[string]*Library), FileSystem: fsys}
 
 	log.Println("Building logical library tree from physical volumes...")
 
-	// Iterate over all physical volumes discovered by phys.BuildPhysTree
-	// No explicit locking on phys.PhysTree is needed as it's treated as read-only after initial build.
-	if len(phys.PhysTree) == 0 {
+	if len(snapshot.Volumes) == 0 {
 		log.Println("No physical volumes found to build logical tree. Ensure 'rsdish scan mp' and 'rsdish scan lib' run first.")
-		return
+		return inv
 	}
 
-	for basePath, volConfig := range phys.PhysTree {
+	for basePath, volConfig := range snapshot.Volumes {
 		libraryUUID := volConfig.Library.UUID
 		volumeMode := volConfig.Volume.Mode
 
-		// If the library doesn't exist in LogiTree yet, create it
-		if _, exists := LogiTree[libraryUUID]; !exists {
-			LogiTree[libraryUUID] = &Library{
+		// If the library doesn't exist in the inventory yet, create it
+		library, exists := inv.Libraries[libraryUUID]
+		if !exists {
+			library = &Library{
 				UUID:     libraryUUID,
 				Buffers:  []*Volume{},
 				Storages: []*Volume{},
+				Partials: []*Volume{},
 			}
+			inv.Libraries[libraryUUID] = library
 		}
 
+		// The budget and filter were validated during the scan.
+		budget, _ := persist.ParseSize(volConfig.Volume.Budget)
+		filter, _ := persist.CompileFilter(volConfig.Filter)
+
 		// Create a new logical Volume object
 		logicalVolume := &Volume{
 			UUID:     libraryUUID, // The library UUID this volume belongs to
+			ID:       volConfig.Volume.ID,
 			Mode:     volumeMode,
+			Budget:   budget,
+			Filter:   filter,
 			BasePath: basePath, // The base path of the volume.toml (its parent directory)
+			Aliases:  snapshot.Aliases[basePath],
+			FS:       snapshot.FSInfo[basePath],
 			Config:   volConfig,
 		}
 
 		// Add the logical volume to the appropriate slice within its library
 		switch volumeMode {
 		case "buffer":
-			LogiTree[libraryUUID].Buffers = append(LogiTree[libraryUUID].Buffers, logicalVolume)
+			library.Buffers = append(library.Buffers, logicalVolume)
 			log.Printf("  Added buffer volume '%s' to library '%s'", basePath, libraryUUID)
 		case "storage":
-			LogiTree[libraryUUID].Storages = append(LogiTree[libraryUUID].Storages, logicalVolume)
+			library.Storages = append(library.Storages, logicalVolume)
 			log.Printf("  Added storage volume '%s' to library '%s'", basePath, libraryUUID)
+		case "partial":
+			library.Partials = append(library.Partials, logicalVolume)
+			log.Printf("  Added partial volume '%s' to library '%s'", basePath, libraryUUID)
 		default:
 			// This case should ideally not be hit if phys.validateVolumeConfig is robust
 			log.Printf("  Warning: Volume '%s' has unknown mode '%s'. Skipping.", basePath, volumeMode)
 		}
 	}
 
-	log.Printf("Finished building logical library tree. Found %d libraries.", len(LogiTree))
+	// Keep volume order stable across runs, since the snapshot is a map.
+	for _, library := range inv.Libraries {
+		sortVolumes(library.Buffers)
+		sortVolumes(library.Storages)
+		sortVolumes(library.Partials)
+		library.Tombstones = loadLibraryTombstones(inv.FileSystem, library)
+		library.Meta = loadLibraryMeta(inv.FileSystem, library)
+	}
+
+	log.Printf("Finished building logical library tree. Found %d libraries.", len(inv.Libraries))
+	return inv
+}
+
+// UUIDs returns the UUIDs of all libraries in alphabetical order.
+func (inv *Inventory) UUIDs() []string {
+	uuids := make([]string, 0, len(inv.Libraries))
+	for uuid := range inv.Libraries {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+// sortVolumes orders volumes by their base path.
+func sortVolumes(volumes []*Volume) {
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].BasePath < volumes[j].BasePath
+	})
 }
@@ -0,0 +1,225 @@
+package logi
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"rsdish/persist"
+	"rsdish/phys"
+)
+
+const (
+	testLibrary = "11111111-1111-1111-1111-111111111111"
+	testOther   = "22222222-2222-2222-2222-222222222222"
+)
+
+// testVolume is a volume.toml written into a temporary mount point.
+type testVolume struct {
+	library string
+	id      string
+	mode    string
+	extra   string // Appended to volume.toml, e.g. an [advanced] or [filter] table
+	files   map[string]string
+}
+
+// scanTempVolumes writes each volume into a mount point of its own below a temporary
+// directory and scans them. It returns the inventory and the base path of each volume.
+func scanTempVolumes(t *testing.T, volumes ...testVolume) (*Inventory, []string) {
+	t.Helper()
+	tmp := t.TempDir()
+	var mounts phys.StaticMounts
+	var basePaths []string
+	for i, vol := range volumes {
+		basePath := filepath.Join(tmp, "disk"+string(rune('a'+i)))
+		content := "[library]\nuuid = \"" + vol.library + "\"\n\n[volume]\nid = \"" + vol.id + "\"\nmode = \"" + vol.mode + "\"\n" + vol.extra
+		writeTestFile(t, filepath.Join(basePath, persist.VolumeConfigFileName), content)
+		for relPath, data := range vol.files {
+			writeTestFile(t, filepath.Join(basePath, filepath.FromSlash(relPath)), data)
+		}
+		mounts = append(mounts, phys.MountInfo{MountPoint: basePath, FSType: "ext4"})
+		basePaths = append(basePaths, basePath)
+	}
+
+	scanner := &phys.Scanner{Mounts: mounts, FS: phys.OSFileSystem{}}
+	return Scan(scanner), basePaths
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewInventoryGroupsVolumes(t *testing.T) {
+	inv, basePaths := scanTempVolumes(t,
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000001", mode: "storage"},
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000002", mode: "storage"},
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000003", mode: "buffer"},
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000004", mode: "partial"},
+		testVolume{library: testOther, id: "bbbbbbbb-0000-0000-0000-000000000001", mode: "storage"},
+	)
+
+	if got := inv.UUIDs(); !slices.Equal(got, []string{testLibrary, testOther}) {
+		t.Fatalf("libraries = %v, want [%s %s]", got, testLibrary, testOther)
+	}
+	library := inv.Libraries[testLibrary]
+	if len(library.Storages) != 2 || len(library.Buffers) != 1 || len(library.Partials) != 1 {
+		t.Errorf("library has %d storages, %d buffers and %d partials, want 2, 1 and 1",
+			len(library.Storages), len(library.Buffers), len(library.Partials))
+	}
+	if buffer := library.Buffers[0]; buffer.BasePath != basePaths[2] || buffer.ID != "aaaaaaaa-0000-0000-0000-000000000003" {
+		t.Errorf("buffer = %s (%s), want %s", buffer.BasePath, buffer.ID, basePaths[2])
+	}
+	if other := inv.Libraries[testOther]; len(other.Storages) != 1 || other.Storages[0].BasePath != basePaths[4] {
+		t.Errorf("storages of the other library = %v, want only %s", other.Storages, basePaths[4])
+	}
+}
+
+// recordingFS is the operating system's filesystem, remembering every path read,
+// opened or walked through it.
+type recordingFS struct {
+	phys.OSFileSystem
+	paths map[string]bool
+}
+
+func (f recordingFS) ReadFile(name string) ([]byte, error) {
+	f.paths[name] = true
+	return f.OSFileSystem.ReadFile(name)
+}
+
+func (f recordingFS) Open(name string) (fs.File, error) {
+	f.paths[name] = true
+	return f.OSFileSystem.Open(name)
+}
+
+func (f recordingFS) WalkDir(root string, fn fs.WalkDirFunc) error {
+	f.paths[root] = true
+	return f.OSFileSystem.WalkDir(root, fn)
+}
+
+func TestInventoryReadsThroughItsFileSystem(t *testing.T) {
+	scanned, basePaths := scanTempVolumes(t,
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000001", mode: "buffer",
+			files: map[string]string{"movies/a.mkv": "a"}},
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000002", mode: "storage",
+			files: map[string]string{"movies/a.mkv": "a"}},
+	)
+	buffer, storage := basePaths[0], basePaths[1]
+	journal := persist.NewTombstoneJournal(testLibrary)
+	journal.Add(persist.Tombstone{Path: "movies/b.mkv", Deleted: time.Now().UTC()})
+	if err := persist.SaveTombstones(storage, journal); err != nil {
+		t.Fatal(err)
+	}
+	fsys := recordingFS{paths: map[string]bool{}}
+
+	inv := NewInventory(nil, scanned.Phys, fsys)
+	if _, err := inv.PlanDrain(testLibrary, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inv.EstimateIncoming(inv.Libraries[testLibrary].Storages[0], inv.Libraries[testLibrary].Buffers); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{
+		filepath.Join(storage, persist.MetaDirName, "tombstones.json"), // Tombstone journal
+		persist.LibraryMetaPath(storage),                               // Library metadata
+		buffer,                                                         // Walk of the buffer
+		filepath.Join(buffer, "movies", "a.mkv"),                       // Hashes compared before draining
+		filepath.Join(storage, "movies", "a.mkv"),
+	} {
+		if !fsys.paths[path] {
+			t.Errorf("'%s' was not read through the inventory's filesystem", path)
+		}
+	}
+	if len(inv.Libraries[testLibrary].Tombstones.Tombstones) != 1 {
+		t.Errorf("tombstones = %v, want the one saved on the storage", inv.Libraries[testLibrary].Tombstones.Tombstones)
+	}
+}
+
+func TestBuildSyncCopiesBothWays(t *testing.T) {
+	inv, basePaths := scanTempVolumes(t,
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000001", mode: "storage"},
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000002", mode: "storage",
+			extra: "\n[filter]\nexclude = [\"*.tmp\"]\n"},
+	)
+	listDir := t.TempDir()
+
+	ops := inv.BuildSync(testLibrary, listDir)
+
+	if len(ops) != 2 {
+		t.Fatalf("BuildSync returned %d operations, want 2", len(ops))
+	}
+	pairs := map[[2]string]Operation{}
+	for _, op := range ops {
+		if op.Kind != OpCopy || op.Command.Program != "rclone" || op.Command.Args[0] != "copy" {
+			t.Errorf("operation %v is not an rclone copy", op.Command)
+		}
+		if op.Command.Args[1] != op.Src.BasePath || op.Command.Args[2] != op.Dst.BasePath {
+			t.Errorf("command %v does not copy from %s to %s", op.Command, op.Src.BasePath, op.Dst.BasePath)
+		}
+		pairs[[2]string{op.Src.BasePath, op.Dst.BasePath}] = op
+	}
+	toFiltered, ok := pairs[[2]string{basePaths[0], basePaths[1]}]
+	if !ok {
+		t.Fatalf("no copy from %s to %s", basePaths[0], basePaths[1])
+	}
+	if _, ok := pairs[[2]string{basePaths[1], basePaths[0]}]; !ok {
+		t.Fatalf("no copy from %s to %s", basePaths[1], basePaths[0])
+	}
+
+	// The filter rules of the destination go into listDir, never into the volume
+	i := slices.Index(toFiltered.Command.Args, "--filter-from")
+	if i < 0 {
+		t.Fatalf("copy onto the filtered volume has no --filter-from: %v", toFiltered.Command)
+	}
+	if rulesPath := toFiltered.Command.Args[i+1]; filepath.Dir(rulesPath) != listDir {
+		t.Errorf("filter rules written to %s, want them in %s", rulesPath, listDir)
+	}
+	if _, err := os.Stat(filepath.Join(basePaths[1], persist.MetaDirName)); !os.IsNotExist(err) {
+		t.Errorf("generating the sync wrote into the volume: %v", err)
+	}
+}
+
+func TestLinkLibraryCreatesSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs extra privileges on Windows")
+	}
+	inv, basePaths := scanTempVolumes(t,
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000001", mode: "storage",
+			files: map[string]string{"movies/a.mkv": "a"}},
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000002", mode: "storage",
+			extra: "\n[advanced]\nlink_create = \"symlink\"\n", files: map[string]string{"movies/b.mkv": "b"}},
+	)
+	link := filepath.Join(basePaths[1], "movies", "a.mkv")
+
+	if err := inv.LinkLibrary(testLibrary, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Fatalf("dry run created '%s': %v", link, err)
+	}
+
+	if err := inv.LinkLibrary(testLibrary, false); err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("'%s' is not a symlink: %v", link, err)
+	}
+	if want := filepath.Join(basePaths[0], "movies", "a.mkv"); target != want {
+		t.Errorf("'%s' points to '%s', want '%s'", link, target, want)
+	}
+	// The first volume does not ask for links and gets none
+	if _, err := os.Lstat(filepath.Join(basePaths[0], "movies", "b.mkv")); !os.IsNotExist(err) {
+		t.Errorf("a link was created on a volume with link_create 'none': %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package logi
+
+import (
+	"fmt"
+	"log"
+
+	"rsdish/persist"
+	"rsdish/phys"
+)
+
+// RefreshManifest rescans a volume through fsys and stores the resulting manifest inside
+// it. The previous manifest, if any, is used to skip rehashing unchanged files.
+func RefreshManifest(fsys phys.FileSystem, vol *Volume, hash bool) (*persist.Manifest, error) {
+	if vol.FS != nil && vol.FS.ReadOnly {
+		return nil, fmt.Errorf("volume '%s' is mounted read-only", vol.BasePath)
+	}
+
+	prev, err := persist.LoadManifest(fsys, vol.BasePath)
+	if err != nil {
+		log.Printf("Warning: Ignoring unreadable manifest of '%s': %v", vol.BasePath, err)
+		prev = nil
+	}
+
+	manifest, stats, err := persist.ScanManifest(fsys, vol.BasePath, prev, hash, vol.Filter)
+	if err != nil {
+		return nil, err
+	}
+	manifest.LibraryUUID = vol.UUID
+	manifest.VolumeID = vol.ID
+
+	if err := persist.SaveManifest(vol.BasePath, manifest); err != nil {
+		return nil, err
+	}
+
+	CatalogVolume(vol, manifest.Entries)
+
+	log.Printf("Indexed %d files in '%s' (%d hashed, %d hashes reused).", stats.Files, vol.BasePath, stats.Hashed, stats.Reused)
+	return manifest, nil
+}
+
+// RefreshLibraryManifests refreshes the manifest of every connected volume of a library.
+func (inv *Inventory) RefreshLibraryManifests(uuid string, hash bool) error {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return fmt.Errorf("library with UUID '%s' not found", uuid)
+	}
+
+	for _, vol := range library.AllVolumes() {
+		if _, err := RefreshManifest(inv.FileSystem, vol, hash); err != nil {
+			log.Printf("Error refreshing manifest of '%s': %v", vol.BasePath, err)
+		}
+	}
+	return nil
+}
+
+// RefreshAllManifests refreshes the manifests of all libraries in the inventory.
+func (inv *Inventory) RefreshAllManifests(hash bool) error {
+	if len(inv.Libraries) == 0 {
+		return fmt.Errorf("no libraries found to index")
+	}
+
+	for uuid := range inv.Libraries {
+		if err := inv.RefreshLibraryManifests(uuid, hash); err != nil {
+			log.Printf("Error processing library '%s': %v", uuid, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package logi
+
+import "rsdish/persist"
+
+// Operation kinds.
+const (
+	OpCopy   = "copy"   // Copy files from Src onto Dst
+	OpMove   = "move"   // Rename a file on Dst
+	OpDelete = "delete" // Delete files from Dst
+)
+
+// Operation is a single command of a generated plan together with what it does:
+// which volumes it reads and writes, and, where the plan knows them, which files
+// and how many bytes it touches.
+type Operation struct {
+	Kind     string
+	UUID     string   // UUID of the library the operation belongs to
+	Src      *Volume  // Volume read from; nil for deletions
+	Dst      *Volume  // Volume written to or deleted from
+	Files    []string // Slash-separated paths the operation is limited to; nil if it covers the whole volume
+	RenameTo string   // New path of a moved file
+	FileList string   // File passed to rclone --files-from-raw, if any
+	Bytes    uint64   // Bytes copied or freed, if Sized
+	Sized    bool     // Bytes was estimated; an estimate may well be 0
+	Command  persist.Command
+}
+
+// Commands returns the commands of the operations, in order.
+func Commands(ops []Operation) []persist.Command {
+	cmds := make([]persist.Command, len(ops))
+	for i, op := range ops {
+		cmds[i] = op.Command
+	}
+	return cmds
+}
@@ -0,0 +1,391 @@
+package logi
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"rsdish/persist"
+	"rsdish/phys"
+)
+
+// Transfer is a set of files to copy from one volume onto another.
+type Transfer struct {
+	Src   *Volume
+	Dst   *Volume
+	Files []string // Slash-separated paths relative to the volume
+	Bytes uint64
+}
+
+// SyncPlan is the minimal set of transfers that gives every storage volume of a
+// library every file, with each missing file read from a single source.
+type SyncPlan struct {
+	UUID      string
+	Policy    string // Conflict policy the plan was made with
+	Renames   []*Rename
+	Transfers []*Transfer
+	Conflicts []*Conflict // Paths whose volumes disagree about the content
+	Unplaced  []string    // Paths left below min_copies because no partial volume has room for them
+}
+
+// Rename moves a conflicting version aside on its volume before any copy runs.
+type Rename struct {
+	Vol  *Volume
+	From string // Slash-separated path relative to the volume
+	To   string
+}
+
+// Conflict is a path held by several volumes with differing size or mtime.
+type Conflict struct {
+	Path     string
+	Versions map[*Volume]persist.ManifestEntry
+	Winner   *Volume // Volume whose version is copied everywhere; nil if the conflict was skipped
+}
+
+// PlanSync lists the storage and partial volumes of a library and computes which
+// files each storage is missing. For every such file one source is picked among the
+// volumes holding it, preferring the source with the fewest bytes assigned so far,
+// so that reads are spread over the drives. A volume's [filter] limits both what it
+// offers as a source and what it is expected to hold.
+//
+// Partial volumes are never expected to hold everything: a file is only placed on
+// one while the library has fewer copies of it than min_copies, on the partial with
+// the most room left in its budget (or free space, if it has no budget).
+//
+// Paths whose volumes disagree about the content are resolved with the conflict
+// policy: policy if not empty, otherwise the library's configured conflict_policy.
+func (inv *Inventory) PlanSync(uuid string, policy string) (*SyncPlan, error) {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return nil, fmt.Errorf("library with UUID '%s' not found", uuid)
+	}
+
+	if policy == "" {
+		policy = library.ConflictPolicy()
+	}
+	plan := &SyncPlan{UUID: uuid, Policy: policy}
+
+	// Volumes with an index below full are storages, the rest are partials.
+	vols := library.Replicas()
+	full := len(library.Storages)
+	if len(vols) < 2 {
+		log.Printf("Library '%s' needs at least 2 storage or partial volumes for synchronization. Skipping sync commands.", uuid)
+		return plan, nil
+	}
+
+	listings := make([]map[string]persist.ManifestEntry, len(vols))
+	union := make(map[string]struct{})
+	for i, vol := range vols {
+		listing, err := ListVolume(inv.FileSystem, vol)
+		if err != nil {
+			return nil, err
+		}
+		listings[i] = listing
+		for relPath := range listing {
+			union[relPath] = struct{}{}
+		}
+	}
+
+	paths := make([]string, 0, len(union))
+	for relPath := range union {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+
+	minCopies := library.MinCopies()
+	room := make([]uint64, len(vols))
+	for i := full; i < len(vols); i++ {
+		room[i] = partialRoom(vols[i], listings[i])
+	}
+
+	transfers := make(map[[2]int]*Transfer)
+	assigned := make([]uint64, len(vols))
+	addTransfer := func(src, dst int, relPath string, size uint64) {
+		key := [2]int{src, dst}
+		transfer, ok := transfers[key]
+		if !ok {
+			transfer = &Transfer{Src: vols[src], Dst: vols[dst]}
+			transfers[key] = transfer
+			plan.Transfers = append(plan.Transfers, transfer)
+		}
+		transfer.Files = append(transfer.Files, relPath)
+		transfer.Bytes += size
+		assigned[src] += size
+	}
+
+	for _, relPath := range paths {
+		// A dropped file counts as missing everywhere; it is removed by the pending
+		// deletions instead, and only copied again if it was modified after the drop.
+		// Only storages whose filter accepts the file can miss it; partials are filled
+		// below as needed.
+		var holders, missing []int
+		for i := range vols {
+			if entry, ok := listings[i][relPath]; ok && !library.IsTombstoned(relPath, entry) {
+				holders = append(holders, i)
+			} else if i < full && vols[i].Filter.Match(relPath) {
+				missing = append(missing, i)
+			}
+		}
+		if len(holders) == 0 {
+			continue
+		}
+		if strings.ContainsAny(relPath, "\r\n") {
+			log.Printf("Warning: Cannot list '%s' for rclone --files-from-raw because its name contains a line break. Skipping.", relPath)
+			continue
+		}
+		copies := len(holders) + len(missing)
+
+		if !allSame(listings, holders, relPath) {
+			conflict := &Conflict{Path: relPath, Versions: make(map[*Volume]persist.ManifestEntry)}
+			for _, h := range holders {
+				conflict.Versions[vols[h]] = listings[h][relPath]
+			}
+			plan.Conflicts = append(plan.Conflicts, conflict)
+
+			winner := pickWinner(listings, holders, relPath, policy)
+			if winner < 0 {
+				continue // Skipped: leave every version where it is
+			}
+			conflict.Winner = vols[winner]
+			winnerEntry := listings[winner][relPath]
+
+			// Every holder of a losing version is overwritten by the winner, unless
+			// the policy keeps both, in which case the losing version is renamed and
+			// then copied to the storages under its new name.
+			var losers []int
+			for _, h := range holders {
+				if h != winner && !SameEntry(listings[h][relPath], winnerEntry) {
+					losers = append(losers, h)
+					missing = append(missing, h)
+				}
+			}
+			if policy == persist.ConflictKeepBoth {
+				for _, l := range losers {
+					renamed := conflictName(relPath, vols[l], union)
+					union[renamed] = struct{}{}
+					plan.Renames = append(plan.Renames, &Rename{Vol: vols[l], From: relPath, To: renamed})
+					for i := 0; i < full; i++ {
+						if i != l && vols[i].Filter.Match(renamed) {
+							addTransfer(l, i, renamed, uint64(listings[l][relPath].Size))
+						}
+					}
+				}
+			}
+			holders = []int{winner}
+		}
+
+		size := uint64(listings[holders[0]][relPath].Size)
+		for copies < minCopies {
+			p := pickPartial(vols, listings, room, full, relPath, size)
+			if p < 0 {
+				if full < len(vols) {
+					plan.Unplaced = append(plan.Unplaced, relPath)
+				}
+				break
+			}
+			missing = append(missing, p)
+			room[p] -= size
+			copies++
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		src := holders[0]
+		for _, h := range holders[1:] {
+			if assigned[h] < assigned[src] {
+				src = h
+			}
+		}
+
+		for _, dst := range missing {
+			addTransfer(src, dst, relPath, size)
+		}
+	}
+
+	return plan, nil
+}
+
+// partialRoom returns how many more bytes a partial volume may take in: what is left
+// of its budget, or its free space if it has no budget.
+func partialRoom(vol *Volume, listing map[string]persist.ManifestEntry) uint64 {
+	if vol.Budget == 0 {
+		usage, err := phys.GetDiskUsage(vol.BasePath)
+		if err != nil {
+			log.Printf("Warning: Partial volume '%s' has no budget and its free space is unknown; nothing will be placed on it: %v", vol.BasePath, err)
+			return 0
+		}
+		return usage.Free
+	}
+
+	var used uint64
+	for _, entry := range listing {
+		used += uint64(entry.Size)
+	}
+	if used >= vol.Budget {
+		return 0
+	}
+	return vol.Budget - used
+}
+
+// pickPartial returns the index of the partial volume with the most room left that
+// accepts relPath, does not hold it yet and can fit size more bytes, or -1 if there is none.
+func pickPartial(vols []*Volume, listings []map[string]persist.ManifestEntry, room []uint64, full int, relPath string, size uint64) int {
+	best := -1
+	for i := full; i < len(vols); i++ {
+		if _, holds := listings[i][relPath]; holds || room[i] < size || !vols[i].Filter.Match(relPath) {
+			continue
+		}
+		if best < 0 || room[i] > room[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// pickWinner returns the index of the holder whose version of relPath wins under
+// policy, or -1 if the conflict is to be skipped.
+func pickWinner(listings []map[string]persist.ManifestEntry, holders []int, relPath string, policy string) int {
+	switch policy {
+	case persist.ConflictNewest, persist.ConflictLargest, persist.ConflictKeepBoth:
+	default:
+		return -1
+	}
+
+	better := func(a, b persist.ManifestEntry) bool {
+		switch policy {
+		case persist.ConflictLargest:
+			return a.Size > b.Size || (a.Size == b.Size && a.ModTime > b.ModTime)
+		default: // newest, keep-both
+			return a.ModTime > b.ModTime || (a.ModTime == b.ModTime && a.Size > b.Size)
+		}
+	}
+
+	winner := holders[0]
+	for _, h := range holders[1:] {
+		if better(listings[h][relPath], listings[winner][relPath]) {
+			winner = h
+		}
+	}
+	return winner
+}
+
+// conflictName returns a new name for the version of relPath held by vol, made by
+// adding the volume's short key before the extension, e.g. "movies/a.1f2e3d4c.mkv".
+func conflictName(relPath string, vol *Volume, taken map[string]struct{}) string {
+	ext := path.Ext(relPath)
+	stem := strings.TrimSuffix(relPath, ext)
+	renamed := fmt.Sprintf("%s.%s%s", stem, shortKey(vol), ext)
+	for n := 2; ; n++ {
+		if _, exists := taken[renamed]; !exists {
+			return renamed
+		}
+		renamed = fmt.Sprintf("%s.%s-%d%s", stem, shortKey(vol), n, ext)
+	}
+}
+
+// allSame reports whether every holder of relPath has the same version of it.
+func allSame(listings []map[string]persist.ManifestEntry, holders []int, relPath string) bool {
+	for _, h := range holders[1:] {
+		if !SameEntry(listings[holders[0]][relPath], listings[h][relPath]) {
+			return false
+		}
+	}
+	return true
+}
+
+// IncomingBytes sums the planned bytes per destination volume.
+func (p *SyncPlan) IncomingBytes() map[*Volume]uint64 {
+	incoming := make(map[*Volume]uint64)
+	for _, transfer := range p.Transfers {
+		incoming[transfer.Dst] += transfer.Bytes
+	}
+	return incoming
+}
+
+// CheckFreeSpace warns for every destination whose planned incoming bytes exceed
+// its free space. It returns false if a shortage was detected.
+func (p *SyncPlan) CheckFreeSpace() bool {
+	enough := true
+	for dst, needed := range p.IncomingBytes() {
+		usage, err := phys.GetDiskUsage(dst.BasePath)
+		if err != nil {
+			log.Printf("Warning: Skipping free space check for '%s': %v", dst.BasePath, err)
+			continue
+		}
+		if needed > usage.Free {
+			log.Printf("Warning: %s would be copied onto '%s', but only %s is free.",
+				phys.FormatBytes(needed), dst.BasePath, phys.FormatBytes(usage.Free))
+			enough = false
+		}
+	}
+	return enough
+}
+
+// PlannedSyncOperations writes one file list per transfer into listDir and returns
+// the operations that carry out the plans: the renames of conflicting versions first,
+// then one 'rclone copy --files-from-raw' per transfer.
+func PlannedSyncOperations(plans []*SyncPlan, listDir string) ([]Operation, error) {
+	var ops []Operation
+	for _, plan := range plans {
+		for _, rename := range plan.Renames {
+			ops = append(ops, Operation{
+				Kind:     OpMove,
+				UUID:     plan.UUID,
+				Dst:      rename.Vol,
+				Files:    []string{rename.From},
+				RenameTo: rename.To,
+				Command: persist.BuildRcloneMoveCommand(
+					filepath.Join(rename.Vol.BasePath, filepath.FromSlash(rename.From)),
+					filepath.Join(rename.Vol.BasePath, filepath.FromSlash(rename.To))),
+			})
+		}
+	}
+
+	// Remove lists left over from an earlier run, so the directory matches the script.
+	stale, _ := filepath.Glob(filepath.Join(listDir, "[0-9][0-9][0-9]_*_to_*.txt"))
+	for _, f := range stale {
+		os.Remove(f)
+	}
+
+	n := 0
+	for _, plan := range plans {
+		for _, transfer := range plan.Transfers {
+			n++
+			listPath := filepath.Join(listDir, fmt.Sprintf("%03d_%s_to_%s.txt", n, shortKey(transfer.Src), shortKey(transfer.Dst)))
+			if err := persist.WriteFileList(listPath, transfer.Files); err != nil {
+				return nil, err
+			}
+
+			ops = append(ops, Operation{
+				Kind:     OpCopy,
+				UUID:     plan.UUID,
+				Src:      transfer.Src,
+				Dst:      transfer.Dst,
+				Files:    transfer.Files,
+				FileList: listPath,
+				Bytes:    transfer.Bytes,
+				Sized:    true,
+				Command: persist.BuildRcloneCommand(persist.RcloneOptions{
+					Src:             transfer.Src.BasePath,
+					Dst:             transfer.Dst.BasePath,
+					RcloneArguments: transfer.Dst.Config.Advanced.RcloneArguments,
+					FilesFrom:       listPath,
+				}),
+			})
+		}
+	}
+	return ops, nil
+}
+
+// shortKey returns a short, file name friendly identifier of a volume.
+func shortKey(vol *Volume) string {
+	if vol.ID != "" && len(vol.ID) >= 8 {
+		return vol.ID[:8]
+	}
+	return filepath.Base(vol.BasePath)
+}
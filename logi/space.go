@@ -0,0 +1,103 @@
+package logi
+
+import (
+	"log"
+	"path/filepath"
+
+	"rsdish/persist"
+	"rsdish/phys"
+)
+
+// EstimateIncoming estimates how many bytes copying every source volume onto dst would
+// transfer: the sizes of files that are missing on dst or differ from it in size, and
+// that dst's filter accepts. A relative path present on several sources is only counted once.
+func (inv *Inventory) EstimateIncoming(dst *Volume, srcs []*Volume) (uint64, error) {
+	seen := make(map[string]struct{})
+	var total uint64
+
+	for _, src := range srcs {
+		if src.BasePath == dst.BasePath {
+			continue
+		}
+
+		listing, _, err := persist.ScanManifest(inv.FileSystem, src.BasePath, nil, false, src.Filter)
+		if err != nil {
+			return total, err
+		}
+
+		for relPath, entry := range listing.Entries {
+			if _, counted := seen[relPath]; counted || !dst.Filter.Match(relPath) {
+				continue
+			}
+			dstInfo, err := inv.FileSystem.Stat(filepath.Join(dst.BasePath, filepath.FromSlash(relPath)))
+			if err == nil && dstInfo.Size() == entry.Size {
+				continue
+			}
+
+			seen[relPath] = struct{}{}
+			total += uint64(entry.Size)
+		}
+	}
+
+	return total, nil
+}
+
+// CheckFreeSpace warns when the estimated bytes copied from srcs onto dst exceed the
+// free space of dst. It returns false if a shortage was detected.
+func (inv *Inventory) CheckFreeSpace(dst *Volume, srcs []*Volume) bool {
+	if len(srcs) == 0 {
+		return true
+	}
+
+	usage, err := phys.GetDiskUsage(dst.BasePath)
+	if err != nil {
+		log.Printf("Warning: Skipping free space check for '%s': %v", dst.BasePath, err)
+		return true
+	}
+
+	needed, err := inv.EstimateIncoming(dst, srcs)
+	if err != nil {
+		log.Printf("Warning: Skipping free space check for '%s': %v", dst.BasePath, err)
+		return true
+	}
+
+	if needed > usage.Free {
+		log.Printf("Warning: About %s would be copied onto '%s', but only %s is free.",
+			phys.FormatBytes(needed), dst.BasePath, phys.FormatBytes(usage.Free))
+		return false
+	}
+	return true
+}
+
+// CheckAppendSpace checks that every storage volume of a library can take in its buffers.
+func (inv *Inventory) CheckAppendSpace(uuid string) bool {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return true
+	}
+
+	enough := true
+	for _, storageVol := range library.Storages {
+		if !inv.CheckFreeSpace(storageVol, library.Buffers) {
+			enough = false
+		}
+	}
+	return enough
+}
+
+// CheckSyncSpace checks that every storage volume of a library can take in the files
+// it is missing from the other storages.
+func (inv *Inventory) CheckSyncSpace(uuid string) bool {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return true
+	}
+
+	enough := true
+	for _, storageVol := range library.Storages {
+		if !inv.CheckFreeSpace(storageVol, library.Storages) {
+			enough = false
+		}
+	}
+	return enough
+}
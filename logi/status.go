@@ -0,0 +1,142 @@
+package logi
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"rsdish/persist"
+)
+
+// DefaultMinCopies is the replication factor of a library that does not set min_copies.
+const DefaultMinCopies = 2
+
+// KnownVolume is a storage volume of a library, either connected now or remembered
+// from an earlier scan.
+type KnownVolume struct {
+	ID        string
+	Path      string // Current path if connected, otherwise the last path it was seen at
+	Connected bool
+	LastSeen  time.Time
+}
+
+// UnderReplicated is a file held by fewer storage volumes than the library requires.
+type UnderReplicated struct {
+	Path    string
+	Holders []*KnownVolume
+}
+
+// LibraryStatus is the replication state of a library.
+type LibraryStatus struct {
+	UUID            string
+	MinCopies       int
+	Volumes         []*KnownVolume
+	Files           int
+	UnderReplicated []UnderReplicated
+}
+
+// MinCopies returns the replication factor set in the library metadata, or else the
+// one configured in the volume.toml files of the library, or DefaultMinCopies if none
+// is. If volumes disagree, the first one (by path) wins.
+func (l *Library) MinCopies() int {
+	if l.Meta != nil && l.Meta.MinCopies > 0 {
+		return l.Meta.MinCopies
+	}
+
+	minCopies := 0
+	for _, vol := range l.AllVolumes() {
+		n := vol.Config.Library.MinCopies
+		if n == 0 {
+			continue
+		}
+		if minCopies == 0 {
+			minCopies = n
+		} else if n != minCopies {
+			log.Printf("Warning: Volume '%s' sets min_copies %d, but library '%s' already uses %d.", vol.BasePath, n, l.UUID, minCopies)
+		}
+	}
+	if minCopies == 0 {
+		return DefaultMinCopies
+	}
+	return minCopies
+}
+
+// CatalogVolume records the current listing of a connected volume in the local catalog.
+func CatalogVolume(vol *Volume, listing map[string]persist.ManifestEntry) {
+	err := persist.SaveCatalogEntry(&persist.CatalogEntry{
+		VolumeID:    vol.ID,
+		LibraryUUID: vol.UUID,
+		Mode:        vol.Mode,
+		LastPath:    vol.BasePath,
+		LastSeen:    time.Now().UTC(),
+		Entries:     listing,
+	})
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// LibraryReplication walks the connected storage and partial volumes of a library,
+// records them in the catalog, and counts the copies of every file over all such volumes known
+// from the catalog, connected or not. Dropped files are not counted.
+func (inv *Inventory) LibraryReplication(uuid string) (*LibraryStatus, error) {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return nil, fmt.Errorf("library with UUID '%s' not found", uuid)
+	}
+
+	status := &LibraryStatus{UUID: uuid, MinCopies: library.MinCopies()}
+	listings := make(map[*KnownVolume]map[string]persist.ManifestEntry)
+	connected := make(map[string]bool)
+
+	for _, vol := range library.Replicas() {
+		listing, err := ListVolume(inv.FileSystem, vol)
+		if err != nil {
+			return nil, err
+		}
+		CatalogVolume(vol, listing)
+
+		known := &KnownVolume{ID: vol.ID, Path: vol.BasePath, Connected: true, LastSeen: time.Now().UTC()}
+		status.Volumes = append(status.Volumes, known)
+		listings[known] = listing
+		if vol.ID != "" {
+			connected[vol.ID] = true
+		}
+	}
+
+	catalog, err := persist.LoadCatalog()
+	if err != nil {
+		log.Printf("Warning: Could not load the volume catalog; only connected volumes are counted: %v", err)
+	}
+	for _, entry := range catalog {
+		if entry.LibraryUUID != uuid || (entry.Mode != "storage" && entry.Mode != "partial") || connected[entry.VolumeID] {
+			continue
+		}
+		known := &KnownVolume{ID: entry.VolumeID, Path: entry.LastPath, LastSeen: entry.LastSeen}
+		status.Volumes = append(status.Volumes, known)
+		listings[known] = entry.Entries
+	}
+
+	holders := make(map[string][]*KnownVolume)
+	for _, known := range status.Volumes {
+		for relPath, entry := range listings[known] {
+			if library.IsTombstoned(relPath, entry) {
+				continue
+			}
+			holders[relPath] = append(holders[relPath], known)
+		}
+	}
+
+	status.Files = len(holders)
+	for relPath, h := range holders {
+		if len(h) < status.MinCopies {
+			status.UnderReplicated = append(status.UnderReplicated, UnderReplicated{Path: relPath, Holders: h})
+		}
+	}
+	sort.Slice(status.UnderReplicated, func(i, j int) bool {
+		return status.UnderReplicated[i].Path < status.UnderReplicated[j].Path
+	})
+
+	return status, nil
+}
@@ -1,16 +1,19 @@
 package logi
 
 import (
+	"fmt"
 	"log"
+	"path/filepath"
 	"rsdish/persist"
 )
 
 // BuildRcloneCmdsForCopy is a helper to build a single Rclone copy command.
 // The rclone arguments for this specific copy operation are taken from the 'dstVol's configuration.
-func BuildRcloneCmdsForCopy(srcVol *Volume, dstVol *Volume) string {
+// Lists the command reads are written into listDir. It returns nil if no copy should be made.
+func (inv *Inventory) BuildRcloneCmdsForCopy(srcVol *Volume, dstVol *Volume, listDir string) *persist.Command {
 	// Do not copy a volume to itself
 	if srcVol.BasePath == dstVol.BasePath {
-		return "" // Return empty string if source and destination are the same
+		return nil // Return nil if source and destination are the same
 	}
 
 	// Get the rclone arguments from the destination volume's config, as per requirement.
@@ -23,80 +26,113 @@ func BuildRcloneCmdsForCopy(srcVol *Volume, dstVol *Volume) string {
 		RcloneArguments: rcloneArgs, // Pass rcloneArgs directly, "copy" is handled by persist.BuildRcloneCommand
 	}
 
-	// Build the command string
-	return persist.BuildRcloneCommand(options)
+	// Never copy dropped files back, unless the source holds a file that was added
+	// back after the drop (see IsTombstoned).
+	if library, ok := inv.Libraries[dstVol.UUID]; ok && len(library.Tombstones.Tombstones) > 0 {
+		excludePath := filepath.Join(listDir, fmt.Sprintf("exclude_%s_to_%s.txt", shortKey(srcVol), shortKey(dstVol)))
+		if err := persist.WriteExcludeList(excludePath, library.effectiveTombstones(inv.FileSystem, srcVol)); err != nil {
+			log.Printf("Error: Skipping copy from '%s' to '%s' because its tombstone exclude list could not be written: %v", srcVol.BasePath, dstVol.BasePath, err)
+			return nil
+		}
+		options.ExcludeFrom = excludePath
+	}
+
+	// Honor the destination's [filter]. The rules are written into listDir, next to
+	// the tombstone exclude list, rather than into the destination volume.
+	if !dstVol.Config.Filter.IsEmpty() {
+		rulesPath := filepath.Join(listDir, fmt.Sprintf("filter_%s.rules", shortKey(dstVol)))
+		if err := persist.WriteFilterRules(rulesPath, dstVol.Config.Filter); err != nil {
+			log.Printf("Error: Skipping copy from '%s' to '%s' because its filter rules could not be written: %v", srcVol.BasePath, dstVol.BasePath, err)
+			return nil
+		}
+		options.FilterFrom = rulesPath
+	}
+
+	// Build the command
+	cmd := persist.BuildRcloneCommand(options)
+	return &cmd
+}
+
+// copyOperation wraps the copy command from srcVol to dstVol into an Operation.
+// It returns nil if no copy should be made.
+func (inv *Inventory) copyOperation(srcVol *Volume, dstVol *Volume, listDir string) *Operation {
+	cmd := inv.BuildRcloneCmdsForCopy(srcVol, dstVol, listDir)
+	if cmd == nil {
+		return nil
+	}
+	return &Operation{Kind: OpCopy, UUID: dstVol.UUID, Src: srcVol, Dst: dstVol, Command: *cmd}
 }
 
 //---
 
-// BuildAppendAllLibrary builds Rclone commands for all libraries.
+// BuildAppendAllLibrary builds Rclone operations for all libraries.
 // It generates `rclone copy` commands to move content from each buffer volume
 // to all storage volumes within the same library.
-func BuildAppendAllLibrary() []string {
-	var allCmds []string
-	for _, library := range LogiTree {
-		cmds := BuildAppend(library.UUID)
-		allCmds = append(allCmds, cmds...)
+func (inv *Inventory) BuildAppendAllLibrary(listDir string) []Operation {
+	var allOps []Operation
+	for _, library := range inv.Libraries {
+		ops := inv.BuildAppend(library.UUID, listDir)
+		allOps = append(allOps, ops...)
 	}
-	return allCmds
+	return allOps
 }
 
-// BuildAppend builds Rclone copy commands for a specific library's buffer volumes.
+// BuildAppend builds Rclone copy operations for a specific library's buffer volumes.
 // These commands will copy each buffer's content to all storage volumes in the library.
-func BuildAppend(uuid string) []string {
-	library, ok := LogiTree[uuid]
+func (inv *Inventory) BuildAppend(uuid string, listDir string) []Operation {
+	library, ok := inv.Libraries[uuid]
 	if !ok {
-		log.Printf("Warning: Library with UUID '%s' not found in LogiTree.", uuid)
-		return []string{}
+		log.Printf("Warning: Library with UUID '%s' not found.", uuid)
+		return nil
 	}
 
 	if len(library.Buffers) == 0 || len(library.Storages) == 0 {
 		log.Printf("Library '%s' is missing buffer or storage volumes. Skipping append commands.", uuid)
-		return []string{}
+		return nil
 	}
 
-	var cmds []string
+	var ops []Operation
 	for _, bufferVol := range library.Buffers {
 		for _, storageVol := range library.Storages {
-			cmd := BuildRcloneCmdsForCopy(bufferVol, storageVol)
-			if cmd != "" {
-				cmds = append(cmds, cmd)
+			op := inv.copyOperation(bufferVol, storageVol, listDir)
+			if op != nil {
+				ops = append(ops, *op)
 			}
 		}
 	}
-	return cmds
+	return ops
 }
 
 //---
 
-// BuildSyncAllLibrary builds Rclone commands for all libraries to synchronize
+// BuildSyncAllLibrary builds Rclone operations for all libraries to synchronize
 // content between their storage volumes using bidirectional copy.
-func BuildSyncAllLibrary() []string {
-	var allCmds []string
-	for _, library := range LogiTree {
-		cmds := BuildSync(library.UUID)
-		allCmds = append(allCmds, cmds...)
+func (inv *Inventory) BuildSyncAllLibrary(listDir string) []Operation {
+	var allOps []Operation
+	for _, library := range inv.Libraries {
+		ops := inv.BuildSync(library.UUID, listDir)
+		allOps = append(allOps, ops...)
 	}
-	return allCmds
+	return allOps
 }
 
-// BuildSync builds Rclone copy commands for a specific library.
+// BuildSync builds Rclone copy operations for a specific library.
 // These commands will generate `rclone copy` commands between each unique pair
 // of storage volumes in the library, in both directions, using the destination's
 // rclone_arguments. This avoids redundant command generation.
-func BuildSync(uuid string) []string {
-	library, ok := LogiTree[uuid]
+func (inv *Inventory) BuildSync(uuid string, listDir string) []Operation {
+	library, ok := inv.Libraries[uuid]
 	if !ok {
-		log.Printf("Warning: Library with UUID '%s' not found in LogiTree.", uuid)
-		return []string{}
+		log.Printf("Warning: Library with UUID '%s' not found.", uuid)
+		return nil
 	}
 
 	if len(library.Storages) < 2 {
 		log.Printf("Library '%s' needs at least 2 storage volumes for synchronization. Skipping sync commands.", uuid)
-		return []string{}
+		return nil
 	}
 
-	var cmds []string
+	var ops []Operation
 	storages := library.Storages
 
 	// Iterate over unique pairs (i, j) where i < j to avoid redundant pairs like (2,4) and (4,2)
@@ -106,18 +142,18 @@ func BuildSync(uuid string) []string {
 			vol2 := storages[j]
 
 			// Command 1: Copy from vol1 to vol2, apply vol2's rclone_arguments
-			cmd1 := BuildRcloneCmdsForCopy(vol1, vol2)
-			if cmd1 != "" {
-				cmds = append(cmds, cmd1)
+			op1 := inv.copyOperation(vol1, vol2, listDir)
+			if op1 != nil {
+				ops = append(ops, *op1)
 			}
 
 			// Command 2: Copy from vol2 to vol1, apply vol1's rclone_arguments
-			cmd2 := BuildRcloneCmdsForCopy(vol2, vol1)
-			if cmd2 != "" {
-				cmds = append(cmds, cmd2)
+			op2 := inv.copyOperation(vol2, vol1, listDir)
+			if op2 != nil {
+				ops = append(ops, *op2)
 			}
 		}
 	}
 
-	return cmds
+	return ops
 }
@@ -0,0 +1,187 @@
+package logi
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"rsdish/persist"
+	"rsdish/phys"
+)
+
+// loadLibraryTombstones merges the tombstone journals of all connected volumes of a
+// library, read through fsys.
+func loadLibraryTombstones(fsys phys.FileSystem, library *Library) *persist.TombstoneJournal {
+	merged := persist.NewTombstoneJournal(library.UUID)
+	for _, vol := range library.AllVolumes() {
+		journal, err := persist.LoadTombstones(fsys, vol.BasePath)
+		if err != nil {
+			log.Printf("Warning: Ignoring unreadable tombstone journal of '%s': %v", vol.BasePath, err)
+			continue
+		}
+		merged.Merge(journal)
+	}
+	return merged
+}
+
+// RecordTombstones adds a tombstone for each relative path to the journal of a library
+// and writes the journal onto every connected volume. The size (and hash, if a manifest
+// has one) is taken from the first connected volume still holding the file.
+func (inv *Inventory) RecordTombstones(uuid string, relPaths []string) error {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return fmt.Errorf("library with UUID '%s' not found", uuid)
+	}
+
+	now := time.Now().UTC()
+	for _, relPath := range relPaths {
+		tombstone := persist.Tombstone{Path: filepath.ToSlash(filepath.Clean(relPath)), Deleted: now}
+		for _, vol := range library.AllVolumes() {
+			info, err := inv.FileSystem.Stat(filepath.Join(vol.BasePath, filepath.FromSlash(tombstone.Path)))
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			tombstone.Size = info.Size()
+			if manifest, err := persist.LoadManifest(inv.FileSystem, vol.BasePath); err == nil && manifest != nil {
+				if entry, ok := manifest.Entries[tombstone.Path]; ok && entry.Size == info.Size() {
+					tombstone.SHA256 = entry.SHA256
+				}
+			}
+			break
+		}
+		library.Tombstones.Add(tombstone)
+	}
+
+	return inv.PropagateTombstones(uuid)
+}
+
+// PropagateTombstones writes the merged tombstone journal of a library onto every
+// connected volume whose own journal is missing some of its entries, so that volumes
+// connected later still learn about files dropped while they were away.
+func (inv *Inventory) PropagateTombstones(uuid string) error {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		return fmt.Errorf("library with UUID '%s' not found", uuid)
+	}
+	if len(library.Tombstones.Tombstones) == 0 {
+		return nil
+	}
+
+	for _, vol := range library.AllVolumes() {
+		if vol.FS != nil && vol.FS.ReadOnly {
+			continue
+		}
+		journal, err := persist.LoadTombstones(inv.FileSystem, vol.BasePath)
+		if err == nil && journal != nil && !journal.Merge(library.Tombstones) {
+			continue // Already up to date
+		}
+		if err := persist.SaveTombstones(vol.BasePath, library.Tombstones); err != nil {
+			log.Printf("Error writing tombstone journal to '%s': %v", vol.BasePath, err)
+			continue
+		}
+		log.Printf("Updated tombstone journal on '%s' (%d entries).", vol.BasePath, len(library.Tombstones.Tombstones))
+	}
+	return nil
+}
+
+// IsTombstoned reports whether a file with the given entry is the version of relPath
+// that was dropped from the library. A file modified after its tombstone was written,
+// or one whose size or hash differs from the tombstone's, has been added back and is
+// not tombstoned. Since 'rclone copy' keeps modification times, a file added back
+// usually looks old, so the size and hash are what tell it apart. Hashes are only
+// compared when both the entry and the tombstone have one.
+func (l *Library) IsTombstoned(relPath string, entry persist.ManifestEntry) bool {
+	tombstone, ok := l.Tombstones.Tombstones[relPath]
+	if !ok {
+		return false
+	}
+	if entry.ModTime > tombstone.Deleted.UnixNano() {
+		return false
+	}
+	if (tombstone.Size > 0 || tombstone.SHA256 != "") && entry.Size != tombstone.Size {
+		return false
+	}
+	if tombstone.SHA256 != "" && entry.SHA256 != "" && entry.SHA256 != tombstone.SHA256 {
+		return false
+	}
+	return true
+}
+
+// isDroppedFile reports whether the regular file at fullPath, described by info, is
+// the version of relPath that was dropped, see IsTombstoned. The file is only hashed,
+// through fsys, if everything else matches and the tombstone has a hash to compare with.
+func (l *Library) isDroppedFile(fsys phys.FileSystem, relPath, fullPath string, info fs.FileInfo) bool {
+	entry := persist.ManifestEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	if !l.IsTombstoned(relPath, entry) {
+		return false
+	}
+	if l.Tombstones.Tombstones[relPath].SHA256 != "" {
+		if hash, err := persist.HashFile(fsys, fullPath); err == nil {
+			entry.SHA256 = hash
+		}
+	}
+	return l.IsTombstoned(relPath, entry)
+}
+
+// effectiveTombstones returns the tombstoned paths a copy from src must still skip:
+// all of them except those src holds a file for that was added back after the drop,
+// see IsTombstoned. Files are looked up through fsys.
+func (l *Library) effectiveTombstones(fsys phys.FileSystem, src *Volume) []string {
+	var paths []string
+	for relPath := range l.Tombstones.Tombstones {
+		fullPath := filepath.Join(src.BasePath, filepath.FromSlash(relPath))
+		info, err := fsys.Stat(fullPath)
+		if err == nil && info.Mode().IsRegular() && !l.isDroppedFile(fsys, relPath, fullPath, info) {
+			continue
+		}
+		paths = append(paths, relPath)
+	}
+	return paths
+}
+
+// PendingDeletions returns the 'rclone delete' operations for tombstoned files that are
+// still present on connected volumes of a library, typically on a volume that was not
+// connected when the file was dropped.
+func (inv *Inventory) PendingDeletions(uuid string) []Operation {
+	library, ok := inv.Libraries[uuid]
+	if !ok {
+		log.Printf("Warning: Library with UUID '%s' not found.", uuid)
+		return nil
+	}
+
+	paths := make([]string, 0, len(library.Tombstones.Tombstones))
+	for relPath := range library.Tombstones.Tombstones {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+
+	var ops []Operation
+	for _, vol := range library.AllVolumes() {
+		for _, relPath := range paths {
+			if !vol.Filter.Match(relPath) {
+				continue // Not part of the library on this volume
+			}
+			fullPath := filepath.Join(vol.BasePath, filepath.FromSlash(relPath))
+			info, err := inv.FileSystem.Stat(fullPath)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if !library.isDroppedFile(inv.FileSystem, relPath, fullPath, info) {
+				log.Printf("Keeping '%s': it was added back or modified after being dropped.", fullPath)
+				continue
+			}
+			ops = append(ops, Operation{
+				Kind:    OpDelete,
+				UUID:    uuid,
+				Dst:     vol,
+				Files:   []string{relPath},
+				Bytes:   uint64(info.Size()),
+				Command: persist.BuildRcloneDeleteCommand(fullPath),
+			})
+		}
+	}
+	return ops
+}
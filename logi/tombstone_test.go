@@ -0,0 +1,73 @@
+package logi
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"rsdish/persist"
+)
+
+func TestIsTombstoned(t *testing.T) {
+	deleted := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	before, after := deleted.Add(-time.Hour).UnixNano(), deleted.Add(time.Hour).UnixNano()
+	library := &Library{Tombstones: persist.NewTombstoneJournal(testLibrary)}
+	library.Tombstones.Add(persist.Tombstone{Path: "a.mkv", Deleted: deleted, Size: 100, SHA256: "aaaa"})
+	library.Tombstones.Add(persist.Tombstone{Path: "legacy.mkv", Deleted: deleted})
+
+	cases := []struct {
+		name    string
+		relPath string
+		entry   persist.ManifestEntry
+		want    bool
+	}{
+		{"dropped version", "a.mkv", persist.ManifestEntry{Size: 100, ModTime: before}, true},
+		{"dropped version, hashed", "a.mkv", persist.ManifestEntry{Size: 100, ModTime: before, SHA256: "aaaa"}, true},
+		{"modified after the drop", "a.mkv", persist.ManifestEntry{Size: 100, ModTime: after}, false},
+		{"re-added with an old mtime, other size", "a.mkv", persist.ManifestEntry{Size: 120, ModTime: before}, false},
+		{"re-added with an old mtime, other hash", "a.mkv", persist.ManifestEntry{Size: 100, ModTime: before, SHA256: "bbbb"}, false},
+		{"tombstone without size", "legacy.mkv", persist.ManifestEntry{Size: 5, ModTime: before}, true},
+		{"never dropped", "b.mkv", persist.ManifestEntry{Size: 100, ModTime: before}, false},
+	}
+	for _, c := range cases {
+		if got := library.IsTombstoned(c.relPath, c.entry); got != c.want {
+			t.Errorf("%s: IsTombstoned = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReaddedFileWithOldMtimeIsSynced(t *testing.T) {
+	inv, basePaths := scanTempVolumes(t,
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000001", mode: "storage",
+			files: map[string]string{"movies/a.mkv": "dropped"}},
+		testVolume{library: testLibrary, id: "aaaaaaaa-0000-0000-0000-000000000002", mode: "storage"},
+	)
+	if err := inv.RecordTombstones(testLibrary, []string{"movies/a.mkv"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ops := inv.PendingDeletions(testLibrary); len(ops) != 1 {
+		t.Fatalf("pending deletions of the dropped file = %v, want one", Commands(ops))
+	}
+
+	// The file is deleted, then a new version is copied back in, keeping an mtime
+	// from before the drop
+	readded := filepath.Join(basePaths[0], "movies", "a.mkv")
+	if err := os.WriteFile(readded, []byte("the new version"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(readded, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if ops := inv.PendingDeletions(testLibrary); len(ops) != 0 {
+		t.Errorf("re-added file is deleted: %v", Commands(ops))
+	}
+	library := inv.Libraries[testLibrary]
+	if excluded := library.effectiveTombstones(inv.FileSystem, library.Storages[0]); slices.Contains(excluded, "movies/a.mkv") {
+		t.Errorf("re-added file is excluded from copies")
+	}
+}
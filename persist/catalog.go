@@ -0,0 +1,124 @@
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CatalogEntry is the last known state of a volume, kept on this machine so that
+// volumes which are not connected right now can still be accounted for.
+type CatalogEntry struct {
+	VolumeID    string                   `json:"volume_id"`
+	LibraryUUID string                   `json:"library_uuid"`
+	Mode        string                   `json:"mode"`
+	LastPath    string                   `json:"last_path"`
+	LastSeen    time.Time                `json:"last_seen"`
+	Entries     map[string]ManifestEntry `json:"entries"`
+}
+
+// CatalogDir returns the directory holding the catalog of known volumes. It lives in
+// the data directory rather than a cache, because the replication history of volumes
+// that are not connected cannot be rebuilt.
+func CatalogDir() (string, error) {
+	dataDir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	catalogDir := filepath.Join(dataDir, "catalog")
+	migrateCatalog(catalogDir)
+	return catalogDir, nil
+}
+
+// migrateCatalog moves the catalog from the user cache directory, where older versions
+// kept it, to catalogDir, unless catalogDir already exists.
+func migrateCatalog(catalogDir string) {
+	if _, err := os.Stat(catalogDir); !os.IsNotExist(err) {
+		return
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return
+	}
+	legacyDir := filepath.Join(cacheDir, "rsdish", "catalog")
+	files, err := os.ReadDir(legacyDir)
+	if err != nil {
+		return
+	}
+
+	// Copy rather than rename, since the cache may be on another filesystem
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(legacyDir, f.Name()))
+		if err == nil {
+			err = writeFileAtomic(filepath.Join(catalogDir, f.Name()), data)
+		}
+		if err != nil {
+			log.Printf("Warning: Could not move catalog entry '%s' to '%s': %v", f.Name(), catalogDir, err)
+			return
+		}
+	}
+	os.RemoveAll(legacyDir)
+	log.Printf("Moved the volume catalog from '%s' to '%s'.", legacyDir, catalogDir)
+}
+
+// SaveCatalogEntry records the state of a volume in the catalog.
+// Volumes without a volume ID cannot be recognized later and are not recorded.
+func SaveCatalogEntry(entry *CatalogEntry) error {
+	if entry.VolumeID == "" {
+		return fmt.Errorf("volume at '%s' has no volume ID and cannot be cataloged", entry.LastPath)
+	}
+
+	catalogDir, err := CatalogDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode catalog entry: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(catalogDir, entry.VolumeID+".json"), data)
+}
+
+// LoadCatalog reads every volume recorded in the catalog.
+// Unreadable entries are skipped with a warning.
+func LoadCatalog() ([]*CatalogEntry, error) {
+	catalogDir, err := CatalogDir()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(catalogDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read catalog directory '%s': %w", catalogDir, err)
+	}
+
+	var entries []*CatalogEntry
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		entryPath := filepath.Join(catalogDir, f.Name())
+		data, err := os.ReadFile(entryPath)
+		if err != nil {
+			log.Printf("Warning: Skipping catalog entry '%s': %v", entryPath, err)
+			continue
+		}
+		var entry CatalogEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			log.Printf("Warning: Skipping catalog entry '%s': %v", entryPath, err)
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
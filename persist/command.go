@@ -0,0 +1,206 @@
+package persist
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Command is a single program invocation kept as an argument array, so that it can be
+// executed directly without a shell, or rendered into a script for review.
+type Command struct {
+	Program string   // Executable to run, looked up in PATH
+	Args    []string // Arguments, passed to the program unchanged
+}
+
+// Shell is a command language a Command can be rendered in.
+type Shell int
+
+const (
+	ShellPOSIX      Shell = iota // sh, bash and other POSIX shells
+	ShellCmd                     // cmd.exe, as used for .bat files
+	ShellPowerShell              // Windows PowerShell and PowerShell 7
+	ShellFish                    // The fish shell
+)
+
+// String renders the command for a POSIX shell, e.g. for display.
+func (c Command) String() string {
+	return c.Render(ShellPOSIX)
+}
+
+// Render renders the command as a single line of a script in the given shell, quoting
+// every argument so that the program receives it unchanged.
+func (c Command) Render(shell Shell) string {
+	parts := make([]string, 0, len(c.Args)+1)
+	parts = append(parts, QuoteArgument(shell, c.Program))
+	for _, arg := range c.Args {
+		parts = append(parts, QuoteArgument(shell, arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// QuoteArgument quotes a single argument for the given shell. Arguments made of
+// characters that are never special are returned as they are.
+func QuoteArgument(shell Shell, arg string) string {
+	switch shell {
+	case ShellCmd:
+		return quoteCmd(arg)
+	case ShellPowerShell:
+		return quotePowerShell(arg)
+	case ShellFish:
+		return quoteFish(arg)
+	default:
+		return quotePOSIX(arg)
+	}
+}
+
+// isPlain reports whether arg is non-empty and consists of letters, digits and the
+// given punctuation only.
+func isPlain(arg string, punctuation string) bool {
+	if arg == "" {
+		return false
+	}
+	for _, r := range arg {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(punctuation, r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// quotePOSIX wraps arg in single quotes, inside which a POSIX shell treats every
+// character literally. A single quote inside arg closes the quoting, is escaped with
+// a backslash, and the quoting is reopened.
+func quotePOSIX(arg string) string {
+	if isPlain(arg, "-_./:=+,@") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// quoteFish wraps arg in single quotes. Unlike POSIX shells, fish treats a backslash
+// before a backslash or a single quote inside them as an escape, so both are escaped.
+func quoteFish(arg string) string {
+	if isPlain(arg, "-_./:=+,@") {
+		return arg
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + replacer.Replace(arg) + "'"
+}
+
+// quotePowerShell wraps arg in single quotes, inside which PowerShell only treats
+// single quotes (including the typographic ones) specially; they are doubled.
+// Double quotes inside arguments reach native programs intact only on PowerShell 7.3
+// and later, which pass arguments with $PSNativeCommandArgumentPassing = 'Standard'.
+func quotePowerShell(arg string) string {
+	if isPlain(arg, "-_./:=+\\") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range arg {
+		if strings.ContainsRune("'\u2018\u2019\u201A\u201B", r) {
+			b.WriteRune(r)
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// quoteCmd quotes arg for a line of a batch file run by cmd.exe. The argument is first
+// quoted the way programs split their command line (backslashes before a quote are
+// doubled, quotes are escaped with a backslash); then '%' is doubled, since batch files
+// expand variables even inside quotes. If arg contains a double quote, cmd.exe would
+// lose track of what is quoted, so every character it treats specially is escaped
+// with '^' as well.
+func quoteCmd(arg string) string {
+	if isPlain(arg, "-_./:+\\@") {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	backslashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		b.WriteRune(r)
+	}
+	b.WriteString(strings.Repeat(`\`, backslashes*2))
+	b.WriteByte('"')
+	quoted := b.String()
+
+	escapeAll := strings.ContainsRune(arg, '"')
+	b.Reset()
+	for _, r := range quoted {
+		switch {
+		case r == '%':
+			b.WriteString("%%")
+		case escapeAll && strings.ContainsRune(`()!^"<>&|`, r):
+			b.WriteByte('^')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// SplitArguments splits a string of command line arguments, such as the rclone_arguments
+// of volume.toml, into an argument array. Arguments are separated by whitespace; single
+// quotes keep their content as is, double quotes allow backslash escapes of '"' and '\'.
+func SplitArguments(s string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+				i++
+				current.WriteRune(runes[i])
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in arguments '%s'", quote, s)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,144 @@
+package persist
+
+import (
+	"os/exec"
+	"testing"
+)
+
+// quoteCase is an argument and how it must be quoted for one shell.
+type quoteCase struct {
+	arg  string
+	want string
+}
+
+func runQuoteCases(t *testing.T, shell Shell, cases []quoteCase) {
+	t.Helper()
+	for _, c := range cases {
+		if got := QuoteArgument(shell, c.arg); got != c.want {
+			t.Errorf("QuoteArgument(%d, %q) = %q, want %q", shell, c.arg, got, c.want)
+		}
+	}
+}
+
+func TestQuotePOSIX(t *testing.T) {
+	runQuoteCases(t, ShellPOSIX, []quoteCase{
+		{"/mnt/disk-1/movies.txt", "/mnt/disk-1/movies.txt"},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{`say "hi"`, `'say "hi"'`},
+		{"it's", `'it'\''s'`},
+		{"''", `''\'''\'''`},
+		{"$HOME", "'$HOME'"},
+		{"`date`", "'`date`'"},
+		{"100%", "'100%'"},
+		{"wow!", "'wow!'"},
+		{"a^b", "'a^b'"},
+		{"a&b", "'a&b'"},
+		{"f(1)", "'f(1)'"},
+		{`a\b`, `'a\b'`},
+		{"-rf", "-rf"},
+		{"-n x", "'-n x'"},
+		{"电影/片.mkv", "'电影/片.mkv'"},
+	})
+}
+
+func TestQuoteFish(t *testing.T) {
+	runQuoteCases(t, ShellFish, []quoteCase{
+		{"/mnt/disk-1/movies.txt", "/mnt/disk-1/movies.txt"},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{`say "hi"`, `'say "hi"'`},
+		{"it's", `'it\'s'`},
+		{`a\b`, `'a\\b'`},
+		{`\'`, `'\\\''`},
+		{"$HOME", "'$HOME'"},
+		{"`date`", "'`date`'"},
+		{"100%", "'100%'"},
+		{"wow!", "'wow!'"},
+		{"a^b", "'a^b'"},
+		{"a&b", "'a&b'"},
+		{"f(1)", "'f(1)'"},
+		{"-rf", "-rf"},
+		{"-n x", "'-n x'"},
+		{"电影/片.mkv", "'电影/片.mkv'"},
+	})
+}
+
+func TestQuotePowerShell(t *testing.T) {
+	runQuoteCases(t, ShellPowerShell, []quoteCase{
+		{`C:\Users\a\movies.txt`, `C:\Users\a\movies.txt`},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{`say "hi"`, `'say "hi"'`},
+		{"it's", "'it''s'"},
+		{"\u2018x\u2019", "'\u2018\u2018x\u2019\u2019'"},
+		{"$env:HOME", "'$env:HOME'"},
+		{"`n", "'`n'"},
+		{"100%", "'100%'"},
+		{"wow!", "'wow!'"},
+		{"a^b", "'a^b'"},
+		{"a&b", "'a&b'"},
+		{"f(1)", "'f(1)'"},
+		{"-rf", "-rf"},
+		{"-n x", "'-n x'"},
+		{"电影/片.mkv", "'电影/片.mkv'"},
+	})
+}
+
+func TestQuoteCmd(t *testing.T) {
+	runQuoteCases(t, ShellCmd, []quoteCase{
+		{`C:\Users\a\movies.txt`, `C:\Users\a\movies.txt`},
+		{"", `""`},
+		{"a b", `"a b"`},
+		{`C:\my dir\`, `"C:\my dir\\"`},
+		{"it's", `"it's"`},
+		{"$HOME", `"$HOME"`},
+		{"`date`", "\"`date`\""},
+		{"100%", `"100%%"`},
+		{"%PATH%", `"%%PATH%%"`},
+		{"wow!", `"wow!"`},
+		{"a^b", `"a^b"`},
+		{"a&b", `"a&b"`},
+		{"f(1)", `"f(1)"`},
+		{"-rf", "-rf"},
+		{"-n x", `"-n x"`},
+		{"电影/片.mkv", `"电影/片.mkv"`},
+		// With a double quote every character cmd.exe treats specially is escaped
+		{`say "hi"`, `^"say \^"hi\^"^"`},
+		{`a\"b`, `^"a\\\^"b^"`},
+		{`"&(x)!^%`, `^"\^"^&^(x^)^!^^%%^"`},
+	})
+}
+
+// TestQuoteRoundTrip has the shells found in PATH echo each quoted argument back.
+func TestQuoteRoundTrip(t *testing.T) {
+	args := []string{
+		"plain", "a b", `say "hi"`, "it's", "$HOME", "`date`", "100%", "wow!",
+		"a^b", "a&b", "f(1)", `a\b`, `\'`, "-n x", "电影/片.mkv", "",
+	}
+	shells := []struct {
+		program string
+		shell   Shell
+	}{
+		{"sh", ShellPOSIX},
+		{"bash", ShellPOSIX},
+		{"fish", ShellFish},
+	}
+	for _, s := range shells {
+		path, err := exec.LookPath(s.program)
+		if err != nil {
+			continue
+		}
+		for _, arg := range args {
+			script := "printf '%s' " + QuoteArgument(s.shell, arg)
+			out, err := exec.Command(path, "-c", script).Output()
+			if err != nil {
+				t.Errorf("%s -c %q: %v", s.program, script, err)
+				continue
+			}
+			if string(out) != arg {
+				t.Errorf("%s -c %q printed %q, want %q", s.program, script, out, arg)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package persist
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FilterSection corresponds to the optional [filter] table within VolumeConfig.
+// It decides which files of the library belong on the volume, using rclone-style globs:
+// '*' and '?' do not match '/', '**' does, a leading '/' anchors the pattern at the
+// volume root and a trailing '/' matches everything inside a directory.
+// Excludes win over includes; if any include is given, everything else is excluded.
+type FilterSection struct {
+	Include []string `toml:"include,omitempty"`
+	Exclude []string `toml:"exclude,omitempty"`
+}
+
+// IsEmpty reports whether the section has no rules, i.e. the volume holds every file.
+func (s FilterSection) IsEmpty() bool {
+	return len(s.Include) == 0 && len(s.Exclude) == 0
+}
+
+// Filter is a compiled FilterSection.
+type Filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// CompileFilter compiles the globs of a filter section.
+// An empty section compiles to nil, which matches every path.
+func CompileFilter(section FilterSection) (*Filter, error) {
+	if section.IsEmpty() {
+		return nil, nil
+	}
+
+	filter := &Filter{}
+	for _, pattern := range section.Include {
+		re, err := globToRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		filter.include = append(filter.include, re)
+	}
+	for _, pattern := range section.Exclude {
+		re, err := globToRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		filter.exclude = append(filter.exclude, re)
+	}
+	return filter, nil
+}
+
+// Match reports whether the slash-separated path relative to the volume passes the
+// filter. A nil filter matches every path.
+func (f *Filter) Match(relPath string) bool {
+	if f == nil {
+		return true
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(relPath) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAll reports whether relPath passes every filter.
+func matchAll(filters []*Filter, relPath string) bool {
+	for _, f := range filters {
+		if !f.Match(relPath) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteFilterRules translates a filter section into an rclone filter file (for
+// 'rclone --filter-from') at rulesPath.
+func WriteFilterRules(rulesPath string, section FilterSection) error {
+	var rules []string
+	for _, pattern := range section.Exclude {
+		rules = append(rules, "- "+rclonePattern(pattern))
+	}
+	for _, pattern := range section.Include {
+		rules = append(rules, "+ "+rclonePattern(pattern))
+	}
+	if len(section.Include) > 0 {
+		rules = append(rules, "- **")
+	}
+
+	return WriteFileList(rulesPath, rules)
+}
+
+// rclonePattern rewrites a directory pattern ("dir/") into one matching the files
+// inside it ("dir/**"), which is what the pattern means for rsdish.
+func rclonePattern(pattern string) string {
+	if strings.HasSuffix(pattern, "/") {
+		return pattern + "**"
+	}
+	return pattern
+}
+
+// globToRegexp translates an rclone-style glob into an anchored regular expression.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	glob := rclonePattern(strings.TrimSpace(pattern))
+	if glob == "" {
+		return nil, fmt.Errorf("empty filter pattern")
+	}
+
+	var b strings.Builder
+	if strings.HasPrefix(glob, "/") {
+		b.WriteString("^")
+		glob = glob[1:]
+	} else {
+		b.WriteString("(^|/)")
+	}
+
+	braces := 0
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(glob) {
+				return nil, fmt.Errorf("filter pattern '%s' ends with a backslash", pattern)
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("filter pattern '%s' has an unterminated '['", pattern)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '{':
+			braces++
+			b.WriteString("(?:")
+		case c == '}' && braces > 0:
+			braces--
+			b.WriteString(")")
+		case c == ',' && braces > 0:
+			b.WriteString("|")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if braces > 0 {
+		return nil, fmt.Errorf("filter pattern '%s' has an unterminated '{'", pattern)
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter pattern '%s': %w", pattern, err)
+	}
+	return re, nil
+}
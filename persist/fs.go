@@ -0,0 +1,27 @@
+package persist
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileSystem is what the files inside a volume are read through: the manifest,
+// tombstone journal and library metadata, the walk of the volume and the content
+// hashed. Writes always go to the operating system.
+type FileSystem interface {
+	Stat(name string) (fs.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	Open(name string) (fs.File, error)
+	WalkDir(root string, fn fs.WalkDirFunc) error // Like filepath.WalkDir, passing full paths
+}
+
+// OSFileSystem is the FileSystem of the operating system.
+type OSFileSystem struct{}
+
+func (OSFileSystem) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+func (OSFileSystem) ReadFile(name string) ([]byte, error)  { return os.ReadFile(name) }
+func (OSFileSystem) Open(name string) (fs.File, error)     { return os.Open(name) }
+func (OSFileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return filepath.WalkDir(root, fn)
+}
@@ -0,0 +1,94 @@
+package persist
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const libraryMetaFileName = "library.toml"
+
+// LibraryMeta describes a library as a whole. A copy is kept on every volume of the
+// library in <volume>/.rsdish/library.toml; when copies disagree, the one with the
+// highest Revision wins.
+type LibraryMeta struct {
+	UUID           string    `toml:"uuid"`
+	Name           string    `toml:"name,omitempty"`
+	Description    string    `toml:"description,omitempty"`
+	Owner          string    `toml:"owner,omitempty"`
+	Created        time.Time `toml:"created"`
+	Updated        time.Time `toml:"updated"`                   // Time of the last change, used to order equal revisions
+	Revision       int       `toml:"revision"`                  // Incremented on every change
+	ConflictPolicy string    `toml:"conflict_policy,omitempty"` // Overrides conflict_policy of volume.toml
+	MinCopies      int       `toml:"min_copies,omitzero"`       // Overrides min_copies of volume.toml
+}
+
+// NewLibraryMeta returns revision 0 of the metadata of a library.
+func NewLibraryMeta(libraryUUID string) *LibraryMeta {
+	now := time.Now().UTC()
+	return &LibraryMeta{UUID: libraryUUID, Created: now, Updated: now}
+}
+
+// LibraryMetaPath returns the location of the library metadata inside the volume at basePath.
+func LibraryMetaPath(basePath string) string {
+	return filepath.Join(basePath, MetaDirName, libraryMetaFileName)
+}
+
+// LoadLibraryMeta reads the library metadata of the volume at basePath.
+// If the volume has none yet, it returns nil and no error.
+func LoadLibraryMeta(fsys FileSystem, basePath string) (*LibraryMeta, error) {
+	metaPath := LibraryMetaPath(basePath)
+	data, err := fsys.ReadFile(metaPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read library metadata '%s': %w", metaPath, err)
+	}
+
+	var meta LibraryMeta
+	if _, err := toml.Decode(string(data), &meta); err != nil {
+		return nil, fmt.Errorf("failed to decode library metadata '%s': %w", metaPath, err)
+	}
+	if meta.ConflictPolicy != "" && !IsConflictPolicy(meta.ConflictPolicy) {
+		return nil, fmt.Errorf("library metadata '%s' has invalid 'conflict_policy': '%s'", metaPath, meta.ConflictPolicy)
+	}
+	if meta.MinCopies < 0 {
+		return nil, fmt.Errorf("library metadata '%s' has invalid 'min_copies': %d", metaPath, meta.MinCopies)
+	}
+	return &meta, nil
+}
+
+// SaveLibraryMeta writes the library metadata into the volume at basePath.
+func SaveLibraryMeta(basePath string, meta *LibraryMeta) error {
+	data, err := toml.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode library metadata: %w", err)
+	}
+	return writeFileAtomic(LibraryMetaPath(basePath), data)
+}
+
+// Newer reports whether m supersedes other: it has a higher revision, or the same
+// revision with a later update time. A nil other is always superseded.
+func (m *LibraryMeta) Newer(other *LibraryMeta) bool {
+	if other == nil {
+		return true
+	}
+	if m.Revision != other.Revision {
+		return m.Revision > other.Revision
+	}
+	return m.Updated.After(other.Updated)
+}
+
+// Equal reports whether two copies of the metadata carry the same content.
+func (m *LibraryMeta) Equal(other *LibraryMeta) bool {
+	if other == nil {
+		return false
+	}
+	return m.UUID == other.UUID && m.Name == other.Name && m.Description == other.Description &&
+		m.Owner == other.Owner && m.Created.Equal(other.Created) && m.Updated.Equal(other.Updated) &&
+		m.Revision == other.Revision && m.ConflictPolicy == other.ConflictPolicy && m.MinCopies == other.MinCopies
+}
@@ -8,10 +8,23 @@ import (
 	"strings"
 )
 
+// cheatfileContent is the whole content of a cheatfile placeholder.
+const cheatfileContent = "cheatfile"
+
+// IsCheatfile reports whether the regular file at path, of the given size, is a cheatfile placeholder.
+func IsCheatfile(fsys FileSystem, path string, size int64) bool {
+	if size > int64(len(cheatfileContent))+2 { // Allow for a trailing newline
+		return false
+	}
+	content, err := fsys.ReadFile(path)
+	return err == nil && strings.TrimSpace(string(content)) == cheatfileContent
+}
+
 // LinkAll enumerates files in a source directory and creates links in a destination directory.
 // It checks for existing files at the destination, but ignores symbolic links and "cheat files."
 // The type of link created is determined by the `linkCreate` parameter.
-func LinkAll(srcPath string, dstPath string, linkCreate string) error {
+// Only files that pass every one of the given filters are linked.
+func LinkAll(srcPath string, dstPath string, linkCreate string, filters ...*Filter) error {
 	if linkCreate == "none" {
 		return nil // Do nothing if the linking mode is 'none'
 	}
@@ -26,6 +39,12 @@ func LinkAll(srcPath string, dstPath string, linkCreate string) error {
 		if err != nil {
 			return err
 		}
+		if d.IsDir() {
+			if relPath, err := filepath.Rel(srcPath, path); err == nil && isSkippedDir(relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
 		// Process only regular files
 		if d.Type().IsRegular() {
@@ -33,6 +52,9 @@ func LinkAll(srcPath string, dstPath string, linkCreate string) error {
 			if err != nil {
 				return fmt.Errorf("failed to get relative path for '%s': %w", path, err)
 			}
+			if !matchAll(filters, filepath.ToSlash(relPath)) {
+				return nil
+			}
 			dstFilePath := filepath.Join(dstPath, relPath)
 
 			// Check if a file with the same name already exists at the destination
@@ -50,7 +72,7 @@ func LinkAll(srcPath string, dstPath string, linkCreate string) error {
 
 				// Read a small part of the file to check for "cheatfile" content
 				content, readErr := os.ReadFile(dstFilePath)
-				if readErr == nil && strings.TrimSpace(string(content)) == "cheatfile" {
+				if readErr == nil && strings.TrimSpace(string(content)) == cheatfileContent {
 					return createLink(path, dstFilePath, linkCreate)
 				}
 
@@ -94,7 +116,7 @@ func createLink(src string, dst string, mode string) error {
 	case "cheatfile":
 		// Remove existing entry
 		os.Remove(dst)
-		if err := os.WriteFile(dst, []byte("cheatfile"), 0644); err != nil {
+		if err := os.WriteFile(dst, []byte(cheatfileContent), 0644); err != nil {
 			return fmt.Errorf("failed to create cheatfile at '%s': %w", dst, err)
 		}
 		fmt.Printf("Created cheatfile: %s\n", dst)
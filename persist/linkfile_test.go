@@ -0,0 +1,42 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkAllSkipsMetadataAndSystemDirs(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	for _, relPath := range []string{
+		"movies/a.mkv",
+		MetaDirName + "/tombstones.json",
+		ProbeDirPrefix + "123/probe",
+		"lost+found/#1234",
+	} {
+		path := filepath.Join(src, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := LinkAll(src, dst, "cheatfile"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "movies", "a.mkv")); err != nil {
+		t.Errorf("movies/a.mkv not linked: %v", err)
+	}
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "movies" {
+			t.Errorf("'%s' linked onto the destination", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package persist
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// MetaDirName is the directory inside a volume where rsdish keeps its own files.
+	// It is never copied between volumes.
+	MetaDirName = ".rsdish"
+
+	// ManifestVersion is the manifest format written by this version of rsdish.
+	ManifestVersion = 1
+
+	// ProbeDirPrefix starts the name of the scratch directories made in a volume to
+	// probe its filesystem. They are removed right away, but one left behind by a
+	// crash is never indexed or copied.
+	ProbeDirPrefix = ".rsdish-probe-"
+
+	manifestFileName = "manifest.json"
+)
+
+// systemDirNames are the directories operating systems keep at the root of a drive.
+// A volume whose volume.toml sits at the mount point holds them, but they are never
+// part of the library, so they are neither indexed, linked nor copied.
+var systemDirNames = []string{"lost+found", "System Volume Information", "$RECYCLE.BIN"}
+
+// isSkippedDir reports whether the directory at relPath, relative to a volume, holds
+// no library content: rsdish's own metadata and probe directories and the system
+// directories. Walks over a volume's files skip it.
+func isSkippedDir(relPath string) bool {
+	return relPath == MetaDirName || strings.HasPrefix(filepath.Base(relPath), ProbeDirPrefix) || isSystemDir(relPath)
+}
+
+// isSystemDir reports whether relPath, relative to a volume, is one of systemDirNames.
+func isSystemDir(relPath string) bool {
+	for _, name := range systemDirNames {
+		if relPath == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Manifest is the file index of a single volume, stored in <volume>/.rsdish/manifest.json.
+type Manifest struct {
+	Version     int                      `json:"version"`
+	LibraryUUID string                   `json:"library_uuid"`
+	VolumeID    string                   `json:"volume_id,omitempty"`
+	Updated     time.Time                `json:"updated"`
+	Entries     map[string]ManifestEntry `json:"entries"` // Keyed by slash-separated path relative to the volume
+}
+
+// ManifestEntry describes a single file of a volume.
+type ManifestEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`            // Modification time in Unix nanoseconds
+	SHA256  string `json:"sha256,omitempty"` // Hex SHA-256 of the content, only if hashing was requested
+}
+
+// ManifestStats summarizes the work done by ScanManifest.
+type ManifestStats struct {
+	Files  int // Files indexed
+	Hashed int // Files whose content was hashed during this scan
+	Reused int // Files whose hash was carried over from the previous manifest
+}
+
+// ManifestPath returns the location of the manifest inside the volume at basePath.
+func ManifestPath(basePath string) string {
+	return filepath.Join(basePath, MetaDirName, manifestFileName)
+}
+
+// LoadManifest reads the manifest of the volume at basePath.
+// If the volume has no manifest yet, it returns nil and no error.
+func LoadManifest(fsys FileSystem, basePath string) (*Manifest, error) {
+	manifestPath := ManifestPath(basePath)
+	data, err := fsys.ReadFile(manifestPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read manifest '%s': %w", manifestPath, err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest '%s': %w", manifestPath, err)
+	}
+	if manifest.Version > ManifestVersion {
+		return nil, fmt.Errorf("manifest '%s' has version %d, but this rsdish only understands up to version %d", manifestPath, manifest.Version, ManifestVersion)
+	}
+	return &manifest, nil
+}
+
+// SaveManifest writes the manifest into the volume at basePath.
+func SaveManifest(basePath string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return writeFileAtomic(ManifestPath(basePath), data)
+}
+
+// ScanManifest walks the volume at basePath through fsys and builds a fresh manifest.
+// Files whose size and mtime match prev keep their previous hash, so a refresh only
+// reads the content of new or changed files. If hash is false, no content is read at all.
+// Files the volume's filter does not match are not part of the library and are left out.
+func ScanManifest(fsys FileSystem, basePath string, prev *Manifest, hash bool, filter *Filter) (*Manifest, ManifestStats, error) {
+	var stats ManifestStats
+	manifest := &Manifest{
+		Version: ManifestVersion,
+		Updated: time.Now().UTC(),
+		Entries: make(map[string]ManifestEntry),
+	}
+	if prev != nil {
+		manifest.LibraryUUID = prev.LibraryUUID
+		manifest.VolumeID = prev.VolumeID
+	}
+
+	err := fsys.WalkDir(basePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(basePath, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for '%s': %w", path, err)
+		}
+		if d.IsDir() {
+			if isSkippedDir(relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || relPath == VolumeConfigFileName {
+			return nil // Symlinks created by 'link' are not content of this volume
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if IsCheatfile(fsys, path, info.Size()) {
+			return nil
+		}
+
+		key := filepath.ToSlash(relPath)
+		if !filter.Match(key) {
+			return nil
+		}
+		entry := ManifestEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+
+		if hash {
+			if old, ok := prev.lookup(key); ok && old.SHA256 != "" && old.Size == entry.Size && old.ModTime == entry.ModTime {
+				entry.SHA256 = old.SHA256
+				stats.Reused++
+			} else {
+				sum, err := HashFile(fsys, path)
+				if err != nil {
+					return err
+				}
+				entry.SHA256 = sum
+				stats.Hashed++
+			}
+		}
+
+		manifest.Entries[key] = entry
+		stats.Files++
+		return nil
+	})
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to walk volume '%s': %w", basePath, err)
+	}
+
+	return manifest, stats, nil
+}
+
+// lookup returns the entry for key, tolerating a nil manifest.
+func (m *Manifest) lookup(key string) (ManifestEntry, bool) {
+	if m == nil {
+		return ManifestEntry{}, false
+	}
+	entry, ok := m.Entries[key]
+	return entry, ok
+}
+
+// HashFile returns the hex SHA-256 of the file at path, read through fsys.
+func HashFile(fsys FileSystem, path string) (string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open '%s' for hashing: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash '%s': %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory '%s': %w", dir, err)
+	}
+
+	tmpFile, err := os.CreateTemp(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for '%s': %w", path, err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		return fmt.Errorf("failed to write temporary file for '%s': %w", path, err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file for '%s': %w", path, err)
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename temporary file to '%s': %w", path, err)
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package persist
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -10,40 +12,98 @@ import (
 type RcloneOptions struct {
 	Src             string // Source path for the rclone command
 	Dst             string // Destination path for the rclone command
-	RcloneArguments string // Raw string of rclone flags/arguments for the command
+	RcloneArguments string // Raw string of rclone flags/arguments for the command, split with SplitArguments
+	FilesFrom       string // Optional file listing the relative paths to copy (rclone --files-from-raw)
+	ExcludeFrom     string // Optional file listing filter patterns to skip (rclone --exclude-from)
+	FilterFrom      string // Optional rclone filter file of the destination (rclone --filter-from)
 }
 
 // BuildRcloneCommands takes a slice of RcloneOptions and returns a slice of complete
-// Rclone command strings, suitable for execution. It calls BuildRcloneCommand for each option.
-func BuildRcloneCommands(cmds []RcloneOptions) []string {
-	rcloneCmds := make([]string, len(cmds))
+// Rclone commands, suitable for execution. It calls BuildRcloneCommand for each option.
+func BuildRcloneCommands(cmds []RcloneOptions) []Command {
+	rcloneCmds := make([]Command, len(cmds))
 	for i, opt := range cmds {
 		rcloneCmds[i] = BuildRcloneCommand(opt)
 	}
 	return rcloneCmds
 }
 
-// BuildRcloneCommand constructs a single Rclone command string using 'rclone copy'.
+// BuildRcloneCommand constructs a single Rclone command using 'rclone copy'.
 // Rclone is cross-platform and generally prefers forward slashes for paths.
 // Assumes 'rclone' executable is in the system's PATH.
-func BuildRcloneCommand(options RcloneOptions) string {
-	// Quoting paths to handle spaces and ensure robustness if the command string
-	// is directly used in a shell.
-	src := fmt.Sprintf(`"%s"`, options.Src)
-	dst := fmt.Sprintf(`"%s"`, options.Dst)
-
-	// The RcloneArguments string is trimmed for whitespace and then appended directly.
-	rcloneArgs := strings.TrimSpace(options.RcloneArguments)
-
+func BuildRcloneCommand(options RcloneOptions) Command {
 	// *** Key Change: Using 'rclone copy' instead of 'rclone sync' ***
 	// The base command will always be "rclone copy" followed by source, destination,
 	// and any additional arguments.
-	cmd := fmt.Sprintf("rclone copy %s %s", src, dst)
+	// volume.toml and the .rsdish directory are excluded so that copying never
+	// overwrites the destination's own identity (library UUID and volume ID) or
+	// its manifest with the source's. Leftover probe directories are rsdish's own too,
+	// and the system directories at the root of a drive belong to no library.
+	args := []string{"copy", options.Src, options.Dst,
+		"--exclude", "/" + VolumeConfigFileName, "--exclude", "/" + MetaDirName + "/**",
+		"--exclude", "/" + ProbeDirPrefix + "*/**"}
+	for _, name := range systemDirNames {
+		args = append(args, "--exclude", "/"+name+"/**")
+	}
+
+	// Restrict the copy to an explicit list of files when the transfer was planned.
+	if options.FilesFrom != "" {
+		args = append(args, "--files-from-raw", options.FilesFrom)
+	}
+
+	if options.ExcludeFrom != "" {
+		args = append(args, "--exclude-from", options.ExcludeFrom)
+	}
+	// rclone applies --exclude rules before --filter-from rules, so the excludes
+	// above keep precedence over the includes of a volume filter.
+	if options.FilterFrom != "" {
+		args = append(args, "--filter-from", options.FilterFrom)
+	}
+
+	// rclone_arguments was validated when the volume was discovered; fall back to plain
+	// whitespace splitting should an unchecked string get here.
+	extra, err := SplitArguments(options.RcloneArguments)
+	if err != nil {
+		extra = strings.Fields(options.RcloneArguments)
+	}
+	args = append(args, extra...)
 
-	// Append RcloneArguments only if they are not empty, to avoid trailing spaces.
-	if rcloneArgs != "" {
-		cmd = fmt.Sprintf("%s %s", cmd, rcloneArgs)
+	return Command{Program: "rclone", Args: args}
+}
+
+// BuildRcloneDeleteCommand constructs an 'rclone delete' command for a single file.
+// For safety it always carries '--dry-run'; the user removes it after reviewing the script.
+func BuildRcloneDeleteCommand(path string) Command {
+	return Command{Program: "rclone", Args: []string{"delete", path, "--dry-run"}}
+}
+
+// BuildRcloneDeleteListCommand constructs an 'rclone delete' command removing the files
+// listed in listPath from the directory base. Like BuildRcloneDeleteCommand it carries '--dry-run'.
+func BuildRcloneDeleteListCommand(base string, listPath string) Command {
+	// --files-from-raw, because --files-from trims whitespace and skips comment lines,
+	// which could turn a listed name into that of a file that must not be deleted.
+	return Command{Program: "rclone", Args: []string{"delete", base, "--files-from-raw", listPath, "--dry-run"}}
+}
+
+// BuildRcloneMoveCommand constructs an 'rclone moveto' command renaming a single file.
+func BuildRcloneMoveCommand(src string, dst string) Command {
+	return Command{Program: "rclone", Args: []string{"moveto", src, dst}}
+}
+
+// WriteFileList writes relative paths, one per line. Used for rclone --files-from-raw,
+// the lines are taken verbatim: no whitespace is trimmed and no line is a comment.
+func WriteFileList(path string, files []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for file list '%s': %w", path, err)
 	}
 
-	return cmd
+	var b strings.Builder
+	for _, f := range files {
+		b.WriteString(f)
+		b.WriteString("\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write file list '%s': %w", path, err)
+	}
+	return nil
 }
@@ -0,0 +1,127 @@
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// TombstoneVersion is the tombstone journal format written by this version of rsdish.
+	TombstoneVersion = 1
+
+	tombstoneFileName = "tombstones.json"
+)
+
+// Tombstone records that a file was dropped from a library.
+type Tombstone struct {
+	Path    string    `json:"path"` // Slash-separated path relative to the volume
+	Deleted time.Time `json:"deleted"`
+	Size    int64     `json:"size,omitempty"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// TombstoneJournal is the list of files dropped from a library, stored on every
+// volume of the library in <volume>/.rsdish/tombstones.json.
+type TombstoneJournal struct {
+	Version     int                  `json:"version"`
+	LibraryUUID string               `json:"library_uuid"`
+	Tombstones  map[string]Tombstone `json:"tombstones"` // Keyed by Path
+}
+
+// NewTombstoneJournal returns an empty journal for a library.
+func NewTombstoneJournal(libraryUUID string) *TombstoneJournal {
+	return &TombstoneJournal{
+		Version:     TombstoneVersion,
+		LibraryUUID: libraryUUID,
+		Tombstones:  make(map[string]Tombstone),
+	}
+}
+
+// LoadTombstones reads the tombstone journal of the volume at basePath.
+// If the volume has no journal yet, it returns nil and no error.
+func LoadTombstones(fsys FileSystem, basePath string) (*TombstoneJournal, error) {
+	journalPath := filepath.Join(basePath, MetaDirName, tombstoneFileName)
+	data, err := fsys.ReadFile(journalPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read tombstone journal '%s': %w", journalPath, err)
+	}
+
+	var journal TombstoneJournal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("failed to decode tombstone journal '%s': %w", journalPath, err)
+	}
+	if journal.Version > TombstoneVersion {
+		return nil, fmt.Errorf("tombstone journal '%s' has version %d, but this rsdish only understands up to version %d", journalPath, journal.Version, TombstoneVersion)
+	}
+	if journal.Tombstones == nil {
+		journal.Tombstones = make(map[string]Tombstone)
+	}
+	return &journal, nil
+}
+
+// SaveTombstones writes the journal into the volume at basePath.
+func SaveTombstones(basePath string, journal *TombstoneJournal) error {
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode tombstone journal: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(basePath, MetaDirName, tombstoneFileName), data)
+}
+
+// WriteExcludeList writes an rclone exclude list to path that matches exactly the
+// given slash-separated paths, relative to the root of the copy.
+func WriteExcludeList(path string, relPaths []string) error {
+	patterns := make([]string, 0, len(relPaths))
+	for _, relPath := range relPaths {
+		patterns = append(patterns, "/"+escapeRcloneGlob(relPath))
+	}
+	sort.Strings(patterns)
+	return WriteFileList(path, patterns)
+}
+
+// Merge adds the tombstones of other, keeping the most recent one for each path.
+// It returns true if the journal changed.
+func (j *TombstoneJournal) Merge(other *TombstoneJournal) bool {
+	if other == nil {
+		return false
+	}
+	changed := false
+	for _, tombstone := range other.Tombstones {
+		if j.Add(tombstone) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Add records a tombstone unless a more recent one for the same path exists.
+// It returns true if the journal changed.
+func (j *TombstoneJournal) Add(tombstone Tombstone) bool {
+	existing, ok := j.Tombstones[tombstone.Path]
+	if ok && !tombstone.Deleted.After(existing.Deleted) {
+		return false
+	}
+	j.Tombstones[tombstone.Path] = tombstone
+	return true
+}
+
+// escapeRcloneGlob escapes the characters rclone filter patterns treat specially,
+// so that a path matches only itself.
+func escapeRcloneGlob(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`\*?[]{}`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
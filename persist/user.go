@@ -2,16 +2,37 @@ package persist
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 
 	"github.com/BurntSushi/toml" // Using BurntSushi's TOML parser
 )
 
-// Config represents the structure of the ~/.rsdish TOML file
+// Config represents the structure of the user config TOML file, see ResolveConfigPath
 type Config struct {
 	Collections           []Collection `toml:"collect"`
 	AdditionalMountpoints []string     `toml:"additional_mountpoints"` // Added this field
+
+	// Filters for the system mount points searched for volumes. Patterns use
+	// filepath.Match syntax against the whole mount point; a pattern ending in "/**"
+	// also matches everything beneath it. Additional mount points are never filtered.
+	ExcludeMountpoints     []string `toml:"exclude_mountpoints,omitempty"`
+	IncludeOnlyMountpoints []string `toml:"include_only_mountpoints,omitempty"` // If set, other mount points are skipped
+	ExcludeFSTypes         []string `toml:"exclude_fs_types,omitempty"`         // e.g. "nfs", "cifs"; no effect where the type is unknown (df fallback)
+
+	// MountTimeout limits how long discovery waits for the mount table, a single mount
+	// point or a single volume, as a Go duration such as "30s". "0" waits forever;
+	// empty uses the default.
+	MountTimeout string `toml:"mount_timeout,omitempty"`
+
+	// VolumeRoots are the folders, relative to each mount point, searched for volumes.
+	// Empty means ["volumes"]. A volume.toml directly at the mount point is always found.
+	VolumeRoots []string `toml:"volume_roots,omitempty"`
+	// VolumeSearchDepth is how many folder levels below a volume root can hold a
+	// volume.toml. 0 means the default of 1 (the immediate children); negative means no limit.
+	VolumeSearchDepth int `toml:"volume_search_depth,omitempty"`
 }
 
 // Collection represents a single collection entry in the TOML file
@@ -21,29 +42,128 @@ type Collection struct {
 }
 
 const (
-	configFileName = ".rsdish"
+	// ConfigEnvVar names the environment variable that overrides the config file location.
+	ConfigEnvVar = "RSDISH_CONFIG"
+
+	configDirName        = "rsdish"
+	configFileName       = "config.toml"
+	legacyConfigFileName = ".rsdish" // Location used by older versions, directly in the home directory
 )
 
-// GetConfigPath returns the full path to the .rsdish config file in the user's home directory.
-func GetConfigPath() (string, error) {
+// DefaultConfigPath returns the default location of the config file:
+// $XDG_CONFIG_HOME/rsdish/config.toml, or ~/.config/rsdish/config.toml if
+// XDG_CONFIG_HOME is not set, on macOS too. On Windows it is the rsdish folder
+// in %AppData%.
+func DefaultConfigPath() (string, error) {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if !filepath.IsAbs(configDir) {
+		// The XDG spec says relative paths are invalid and should be ignored
+		if runtime.GOOS == "windows" {
+			dir, err := os.UserConfigDir()
+			if err != nil {
+				return "", fmt.Errorf("failed to get user config directory: %w", err)
+			}
+			configDir = dir
+		} else {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return "", fmt.Errorf("failed to get user home directory: %w", err)
+			}
+			configDir = filepath.Join(homeDir, ".config")
+		}
+	}
+	return filepath.Join(configDir, configDirName, configFileName), nil
+}
+
+// DataDir returns the directory holding state rsdish must not lose, such as the
+// catalog of known volumes: $XDG_DATA_HOME/rsdish, or ~/.local/share/rsdish if
+// XDG_DATA_HOME is not set. On Windows it is the rsdish folder in %AppData%.
+func DataDir() (string, error) {
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if !filepath.IsAbs(dataDir) {
+		if runtime.GOOS == "windows" {
+			dir, err := os.UserConfigDir()
+			if err != nil {
+				return "", fmt.Errorf("failed to get user config directory: %w", err)
+			}
+			dataDir = dir
+		} else {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return "", fmt.Errorf("failed to get user home directory: %w", err)
+			}
+			dataDir = filepath.Join(homeDir, ".local", "share")
+		}
+	}
+	return filepath.Join(dataDir, configDirName), nil
+}
+
+// LegacyConfigPath returns the location of the config file of older versions, ~/.rsdish.
+func LegacyConfigPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("failed to get user home directory: %w", err)
 	}
-	return filepath.Join(homeDir, configFileName), nil
+	return filepath.Join(homeDir, legacyConfigFileName), nil
 }
 
-// LoadConfig reads and parses the .rsdish TOML file into a Config struct.
-// If the file doesn't exist, it returns an empty Config and no error.
-func LoadConfig() (*Config, error) {
-	configPath, err := GetConfigPath()
+// ResolveConfigPath returns the config file to use. In order of precedence this is
+// explicitPath (the --config flag), $RSDISH_CONFIG, or DefaultConfigPath. Only in the
+// last case a legacy ~/.rsdish is copied to the default location if nothing is there yet.
+func ResolveConfigPath(explicitPath string) (string, error) {
+	if explicitPath != "" {
+		return explicitPath, nil
+	}
+	if envPath := os.Getenv(ConfigEnvVar); envPath != "" {
+		return envPath, nil
+	}
+
+	configPath, err := DefaultConfigPath()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	if err := migrateLegacyConfig(configPath); err != nil {
+		return "", err
+	}
+	return configPath, nil
+}
+
+// migrateLegacyConfig copies ~/.rsdish to configPath, unless configPath already exists
+// or there is no legacy file. The legacy file is kept, since older versions of rsdish
+// still read it.
+func migrateLegacyConfig(configPath string) error {
+	if _, err := os.Stat(configPath); err == nil || !os.IsNotExist(err) {
+		return nil // Already migrated, or not ours to decide
+	}
+	legacyPath, err := LegacyConfigPath()
+	if err != nil {
+		return nil // Without a home directory there is nothing to migrate
+	}
+	info, err := os.Stat(legacyPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+
+	data, err := os.ReadFile(legacyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read legacy config file '%s': %w", legacyPath, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory for '%s': %w", configPath, err)
+	}
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to migrate config file to '%s': %w", configPath, err)
+	}
+	log.Printf("Copied config file '%s' to '%s'. From now on only the new file is read; the old one is left for older versions of rsdish.", legacyPath, configPath)
+	return nil
+}
 
+// LoadConfig reads and parses the TOML config file at configPath into a Config struct.
+// If the file doesn't exist, it returns an empty Config and no error.
+func LoadConfig(configPath string) (*Config, error) {
 	var cfg Config
 	// Use toml.DecodeFile which handles file reading and unmarshaling directly.
-	_, err = toml.DecodeFile(configPath, &cfg)
+	_, err := toml.DecodeFile(configPath, &cfg)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// If file doesn't exist, return an empty config without error
@@ -54,15 +174,15 @@ func LoadConfig() (*Config, error) {
 	return &cfg, nil
 }
 
-// SaveConfig writes the Config struct back to the .rsdish TOML file.
-func SaveConfig(cfg *Config) error {
-	configPath, err := GetConfigPath()
-	if err != nil {
-		return err
+// SaveConfig writes the Config struct to the TOML config file at configPath,
+// creating its directory if needed.
+func SaveConfig(cfg *Config, configPath string) error {
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory for '%s': %w", configPath, err)
 	}
 
 	// Create a temporary file to write to first, then rename for atomicity
-	tmpFile, err := os.CreateTemp(filepath.Dir(configPath), configFileName+".tmp")
+	tmpFile, err := os.CreateTemp(filepath.Dir(configPath), filepath.Base(configPath)+".tmp")
 	if err != nil {
 		return fmt.Errorf("failed to create temporary config file: %w", err)
 	}
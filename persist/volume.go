@@ -2,29 +2,58 @@ package persist
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/google/uuid"
 )
 
+// VolumeConfigFileName is the name of the file that marks a directory as an rsdish volume.
+const VolumeConfigFileName = "volume.toml"
+
 // VolumeConfig represents the structure of a volume.toml file.
 type VolumeConfig struct {
 	Library  LibrarySection  `toml:"library"`
 	Volume   VolumeSection   `toml:"volume"`
 	Advanced AdvancedSection `toml:"advanced"` // Reintroducing the advanced section
+	Filter   FilterSection   `toml:"filter,omitempty"`
 }
 
 // LibrarySection corresponds to the [library] table within VolumeConfig.
 type LibrarySection struct {
-	UUID string `toml:"uuid"`
+	UUID           string `toml:"uuid"`
+	ConflictPolicy string `toml:"conflict_policy,omitempty"` // How 'sync' resolves differing versions of a file
+	MinCopies      int    `toml:"min_copies,omitzero"`       // Storage volumes every file should be on
+}
+
+// Conflict policies for files held by several storages with differing content.
+const (
+	ConflictSkip     = "skip"      // Leave every version in place and only report it
+	ConflictNewest   = "newest"    // Copy the most recently modified version everywhere
+	ConflictLargest  = "largest"   // Copy the largest version everywhere
+	ConflictKeepBoth = "keep-both" // Rename the other versions with a volume suffix and keep all of them
+)
+
+// IsConflictPolicy reports whether policy is a known conflict policy.
+func IsConflictPolicy(policy string) bool {
+	switch policy {
+	case ConflictSkip, ConflictNewest, ConflictLargest, ConflictKeepBoth:
+		return true
+	}
+	return false
 }
 
 // VolumeSection corresponds to the [volume] table within VolumeConfig.
+// ID identifies the volume itself and stays the same when the drive is remounted elsewhere.
 type VolumeSection struct {
-	Mode string `toml:"mode"`           // REQUIRED FROM: (storage/buffer)
-	Note string `toml:"note,omitempty"` // Note can be optional
+	ID     string `toml:"id,omitempty"`     // Persistent per-volume UUID, optional for legacy volume.toml files
+	Mode   string `toml:"mode"`             // REQUIRED FROM: (storage/buffer/partial)
+	Note   string `toml:"note,omitempty"`   // Note can be optional
+	Budget string `toml:"budget,omitempty"` // Size limit of a partial volume, e.g. "500G"; defaults to its free space
 }
 
 // AdvancedSection corresponds to the [advanced] table within VolumeConfig.
@@ -34,6 +63,39 @@ type AdvancedSection struct {
 	LinkCreate      string `toml:"link_create,omitempty"`      // Now optional in TOML
 }
 
+// ParseSize parses a byte size such as "1500", "500M", "2T" or "1.5TiB".
+// Units are binary (K = 1024 bytes); an empty string is zero.
+func ParseSize(size string) (uint64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := float64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGTP", s[n-1]); i >= 0 {
+			multiplier = math.Pow(1024, float64(i+1))
+			s = s[:n-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	return uint64(value * multiplier), nil
+}
+
+// LoadVolumeConfig reads and parses a single volume.toml file.
+func LoadVolumeConfig(path string) (*VolumeConfig, error) {
+	var cfg VolumeConfig
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode volume config '%s': %w", path, err)
+	}
+	return &cfg, nil
+}
+
 // SaveTomlConfig writes any TOML-serializable struct to the specified path.
 func SaveTomlConfig(data interface{}, outputPath string) error {
 	marshaledData, err := toml.Marshal(data)
@@ -73,22 +135,18 @@ func SaveTomlConfig(data interface{}, outputPath string) error {
 	return nil
 }
 
-// ResolveCollectionID takes an ID (shortname or UUID) and returns its corresponding UUID.
-func ResolveCollectionID(id string) (string, error) {
+// ResolveCollectionID takes an ID (shortname or UUID) and returns its corresponding UUID
+// from the collections of the config. Unknown shortnames are returned as they are.
+func (cfg *Config) ResolveCollectionID(id string) string {
 	if _, err := uuid.Parse(id); err == nil {
-		return id, nil // It's already a UUID, no resolution needed
-	}
-
-	cfg, err := LoadConfig() // Uses LoadConfig from persist/user.go
-	if err != nil {
-		return id, fmt.Errorf("could not load user config to resolve shortname '%s': %w", id, err)
+		return id // It's already a UUID, no resolution needed
 	}
 
 	for _, col := range cfg.Collections {
 		if col.Short == id {
-			return col.UUID, nil // Found a matching shortname, return its UUID
+			return col.UUID // Found a matching shortname, return its UUID
 		}
 	}
 
-	return id, nil
+	return id
 }
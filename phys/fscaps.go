@@ -0,0 +1,171 @@
+package phys
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rsdish/persist"
+)
+
+// FSCaps describes what the filesystem holding a volume can do.
+type FSCaps struct {
+	FSType        string // Filesystem type from the mount table (empty if unknown)
+	ReadOnly      bool   // Mounted read-only; nothing can be written, so nothing is probed
+	Symlinks      bool   // Symbolic links can be created
+	Hardlinks     bool   // Hard links can be created
+	CaseSensitive bool   // "a.txt" and "A.txt" are different files
+	Reflink       bool   // Copy-on-write clones (FICLONE) are supported
+	MaxNameLen    int    // Maximum length of a single path component in bytes
+	Probed        bool   // True if measured on disk, false if inferred from FSType
+}
+
+// defaultMaxNameLen is the component length limit of virtually every filesystem rsdish meets.
+const defaultMaxNameLen = 255
+
+// knownFSCaps holds the capabilities assumed for a filesystem type when it cannot be probed.
+var knownFSCaps = map[string]FSCaps{
+	"ext2":     {Symlinks: true, Hardlinks: true, CaseSensitive: true},
+	"ext3":     {Symlinks: true, Hardlinks: true, CaseSensitive: true},
+	"ext4":     {Symlinks: true, Hardlinks: true, CaseSensitive: true},
+	"f2fs":     {Symlinks: true, Hardlinks: true, CaseSensitive: true},
+	"jfs":      {Symlinks: true, Hardlinks: true, CaseSensitive: true},
+	"zfs":      {Symlinks: true, Hardlinks: true, CaseSensitive: true},
+	"xfs":      {Symlinks: true, Hardlinks: true, CaseSensitive: true, Reflink: true},
+	"btrfs":    {Symlinks: true, Hardlinks: true, CaseSensitive: true, Reflink: true},
+	"bcachefs": {Symlinks: true, Hardlinks: true, CaseSensitive: true, Reflink: true},
+	"nfs":      {Symlinks: true, Hardlinks: true, CaseSensitive: true},
+	"nfs4":     {Symlinks: true, Hardlinks: true, CaseSensitive: true},
+	"apfs":     {Symlinks: true, Hardlinks: true, Reflink: true},
+	"hfs":      {Symlinks: true, Hardlinks: true},
+	"ntfs":     {Symlinks: true, Hardlinks: true},
+	"ntfs3":    {Symlinks: true, Hardlinks: true},
+	"fuseblk":  {Symlinks: true, Hardlinks: true}, // Usually ntfs-3g
+	"cifs":     {},
+	"smb3":     {},
+	"vfat":     {},
+	"msdos":    {},
+	"exfat":    {},
+}
+
+// probeVolume determines the filesystem capabilities of the volume at basePath.
+// The filesystem type comes from the mount table mounts. If prober is set, writable
+// filesystems are then probed through it; otherwise the capabilities are inferred
+// from the type.
+func probeVolume(basePath string, mounts []MountInfo, prober FSProber) *FSCaps {
+	mount, found := findMount(basePath, mounts)
+
+	caps := inferFSCaps(mount.FSType)
+	caps.FSType = mount.FSType
+	caps.ReadOnly = found && mount.ReadOnly
+	if caps.ReadOnly || prober == nil {
+		return &caps
+	}
+
+	if err := prober.ProbeFSCaps(basePath, &caps); err != nil {
+		log.Printf("Warning: Could not probe filesystem of '%s', assuming defaults for '%s': %v", basePath, caps.FSType, err)
+	}
+	return &caps
+}
+
+// inferFSCaps returns the capabilities assumed for fsType. Unknown filesystems are
+// assumed capable of everything, so that nothing is refused without evidence.
+func inferFSCaps(fsType string) FSCaps {
+	caps, ok := knownFSCaps[fsType]
+	if !ok {
+		caps = FSCaps{Symlinks: true, Hardlinks: true, CaseSensitive: true}
+	}
+	caps.MaxNameLen = defaultMaxNameLen
+	return caps
+}
+
+// probeFSCaps measures the capabilities of the filesystem holding dir by creating a
+// few scratch files in a temporary directory inside it.
+func probeFSCaps(dir string, caps *FSCaps) error {
+	probeDir, err := os.MkdirTemp(dir, persist.ProbeDirPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to create probe directory: %w", err)
+	}
+	defer os.RemoveAll(probeDir)
+
+	probeFile := filepath.Join(probeDir, "probe")
+	if err := os.WriteFile(probeFile, []byte("rsdish"), 0644); err != nil {
+		return fmt.Errorf("failed to create probe file: %w", err)
+	}
+
+	caps.Symlinks = os.Symlink("probe", filepath.Join(probeDir, "probe-symlink")) == nil
+	caps.Hardlinks = os.Link(probeFile, filepath.Join(probeDir, "probe-hardlink")) == nil
+
+	_, err = os.Stat(filepath.Join(probeDir, "PROBE"))
+	caps.CaseSensitive = os.IsNotExist(err)
+
+	if reflink, ok := probeReflink(probeFile, filepath.Join(probeDir, "probe-reflink")); ok {
+		caps.Reflink = reflink
+	}
+	if nameLen, err := maxNameLen(probeDir); err == nil && nameLen > 0 {
+		caps.MaxNameLen = nameLen
+	}
+
+	caps.Probed = true
+	return nil
+}
+
+// findMount returns the mount holding path, i.e. the one with the longest matching mount
+// point. Of several mounts stacked on the same path the last one is visible, so it wins.
+func findMount(path string, mounts []MountInfo) (MountInfo, bool) {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	var best MountInfo
+	found := false
+	for _, m := range mounts {
+		if !isPathWithin(path, m.MountPoint) {
+			continue
+		}
+		if !found || len(m.MountPoint) >= len(best.MountPoint) {
+			best = m
+			found = true
+		}
+	}
+	return best, found
+}
+
+// isPathWithin reports whether path equals dir or lies beneath it.
+func isPathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// adjustLinkCreate downgrades a link_create mode the volume's filesystem cannot honor.
+// Only the in-memory configuration is changed; volume.toml on disk is left as is.
+func adjustLinkCreate(cfg *persist.VolumeConfig, caps *FSCaps, basePath string) {
+	mode := cfg.Advanced.LinkCreate
+	if mode == "" || mode == "none" {
+		return
+	}
+
+	if caps.ReadOnly {
+		log.Printf("Warning: Volume '%s' is mounted read-only; link_create '%s' downgraded to 'none'.", basePath, mode)
+		cfg.Advanced.LinkCreate = "none"
+		return
+	}
+
+	if mode == "symlink" && !caps.Symlinks {
+		log.Printf("Warning: Volume '%s' is on a filesystem (%s) that cannot hold symlinks; link_create downgraded from 'symlink' to 'cheatfile'.", basePath, describeFSType(caps.FSType))
+		cfg.Advanced.LinkCreate = "cheatfile"
+	}
+}
+
+// describeFSType returns a printable filesystem type name.
+func describeFSType(fsType string) string {
+	if fsType == "" {
+		return "unknown"
+	}
+	return fsType
+}
@@ -0,0 +1,37 @@
+package phys
+
+import (
+	"os"
+	"syscall"
+)
+
+// ioctlFICLONE is FICLONE from linux/fs.h: _IOW(0x94, 9, int).
+const ioctlFICLONE = 0x40049409
+
+// probeReflink tries to clone src into a new file dst with the FICLONE ioctl.
+// The second return value reports whether the probe could be carried out at all.
+func probeReflink(src, dst string) (bool, bool) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return false, false
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return false, false
+	}
+	defer dstFile.Close()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dstFile.Fd(), ioctlFICLONE, srcFile.Fd())
+	return errno == 0, true
+}
+
+// maxNameLen returns the maximum length of a path component on the filesystem holding path.
+func maxNameLen(path string) (int, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int(st.Namelen), nil
+}
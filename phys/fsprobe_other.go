@@ -0,0 +1,16 @@
+//go:build !linux
+
+package phys
+
+import "errors"
+
+// probeReflink is only implemented on Linux; elsewhere the value inferred from
+// the filesystem type is kept.
+func probeReflink(src, dst string) (bool, bool) {
+	return false, false
+}
+
+// maxNameLen is only implemented on Linux; elsewhere the default limit is kept.
+func maxNameLen(path string) (int, error) {
+	return 0, errors.New("querying the maximum name length is not supported on this platform")
+}
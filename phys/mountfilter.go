@@ -0,0 +1,126 @@
+package phys
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"rsdish/persist"
+)
+
+// MountCandidate is a mount point considered for volume discovery.
+type MountCandidate struct {
+	MountPoint string     // Cleaned mount point path
+	Mount      *MountInfo // Entry of the mount table; nil for additional mount points
+	Additional bool       // Listed in additional_mountpoints
+	SkipReason string     // Why the mount point is not searched; empty if it is
+}
+
+// MountCandidates applies the mount filters of the scanner's config to the system
+// mounts and the additional mount points, and returns every mount point sorted by
+// path. Mount points listed in additional_mountpoints are always searched.
+func (s *Scanner) MountCandidates(systemMounts []MountInfo) []MountCandidate {
+	cfg := s.Config
+	if cfg == nil {
+		cfg = &persist.Config{}
+	}
+	checkMountPatterns("exclude_mountpoints", cfg.ExcludeMountpoints)
+	checkMountPatterns("include_only_mountpoints", cfg.IncludeOnlyMountpoints)
+
+	byPath := make(map[string]*MountCandidate)
+	for i := range systemMounts {
+		// Of mounts stacked on the same path (e.g. autofs, then the nfs share it mounted)
+		// only the last one is visible, so later entries replace earlier ones
+		mp := filepath.Clean(systemMounts[i].MountPoint)
+		byPath[mp] = &MountCandidate{
+			MountPoint: mp,
+			Mount:      &systemMounts[i],
+			SkipReason: mountSkipReason(cfg, mp, systemMounts[i].FSType),
+		}
+	}
+	for _, amp := range cfg.AdditionalMountpoints {
+		mp := filepath.Clean(amp)
+		if candidate, ok := byPath[mp]; ok {
+			candidate.Additional = true
+			candidate.SkipReason = ""
+			continue
+		}
+		byPath[mp] = &MountCandidate{MountPoint: mp, Additional: true}
+	}
+
+	candidates := make([]MountCandidate, 0, len(byPath))
+	for _, candidate := range byPath {
+		candidates = append(candidates, *candidate)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].MountPoint < candidates[j].MountPoint
+	})
+	return candidates
+}
+
+// mountSkipReason returns why a system mount point is filtered out by cfg, or "" if it is searched.
+func mountSkipReason(cfg *persist.Config, mp, fsType string) string {
+	for _, excluded := range cfg.ExcludeFSTypes {
+		if fsType != "" && strings.EqualFold(fsType, excluded) {
+			return fmt.Sprintf("filesystem type '%s' is in exclude_fs_types", fsType)
+		}
+	}
+	for _, pattern := range cfg.ExcludeMountpoints {
+		if matchMountPattern(pattern, mp) {
+			return fmt.Sprintf("matches exclude_mountpoints pattern '%s'", pattern)
+		}
+	}
+	if len(cfg.IncludeOnlyMountpoints) > 0 {
+		for _, pattern := range cfg.IncludeOnlyMountpoints {
+			if matchMountPattern(pattern, mp) {
+				return ""
+			}
+		}
+		return "matches no include_only_mountpoints pattern"
+	}
+	return ""
+}
+
+// matchMountPattern reports whether mp matches pattern. A pattern ending in "/**"
+// matches the directory before it and every path beneath that directory.
+func matchMountPattern(pattern, mp string) bool {
+	if runtime.GOOS == "windows" {
+		// Drive letters and paths are case-insensitive on Windows
+		pattern, mp = strings.ToLower(pattern), strings.ToLower(mp)
+	}
+
+	recursive := false
+	for _, suffix := range []string{"/**", string(filepath.Separator) + "**"} {
+		if strings.HasSuffix(pattern, suffix) {
+			pattern = strings.TrimSuffix(pattern, suffix)
+			recursive = true
+			break
+		}
+	}
+	if pattern == "" {
+		pattern = string(filepath.Separator) // "/**" matches everything
+	}
+	pattern = filepath.Clean(pattern)
+
+	for p := mp; ; p = filepath.Dir(p) {
+		if matched, _ := filepath.Match(pattern, p); matched {
+			return true
+		}
+		if !recursive || filepath.Dir(p) == p {
+			return false
+		}
+	}
+}
+
+// checkMountPatterns logs the patterns of a config list that filepath.Match rejects;
+// they never match anything.
+func checkMountPatterns(key string, patterns []string) {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			log.Printf("Warning: Invalid %s pattern '%s' in user config: %v", key, pattern, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package phys
+
+import (
+	"testing"
+
+	"rsdish/persist"
+)
+
+func TestMountCandidatesKeepsTopStackedMount(t *testing.T) {
+	// autofs mounts the share on first access; mountinfo lists the nfs mount after it
+	mounts := []MountInfo{
+		{MountPoint: "/mnt/nas", FSType: "autofs"},
+		{MountPoint: "/mnt/nas", FSType: "nfs4"},
+	}
+	scanner := &Scanner{Config: &persist.Config{ExcludeFSTypes: []string{"nfs4"}}}
+
+	candidates := scanner.MountCandidates(mounts)
+
+	if len(candidates) != 1 {
+		t.Fatalf("got %d candidates, want 1", len(candidates))
+	}
+	if got := candidates[0].Mount.FSType; got != "nfs4" {
+		t.Errorf("filesystem type of /mnt/nas = %q, want nfs4", got)
+	}
+	if candidates[0].SkipReason == "" {
+		t.Errorf("/mnt/nas is searched although nfs4 is in exclude_fs_types")
+	}
+}
+
+func TestFindMountKeepsTopStackedMount(t *testing.T) {
+	mounts := []MountInfo{
+		{MountPoint: "/", FSType: "ext4"},
+		{MountPoint: "/mnt/nas", FSType: "autofs"},
+		{MountPoint: "/mnt/nas", FSType: "nfs4"},
+	}
+
+	mount, found := findMount("/mnt/nas/volumes/movies", mounts)
+
+	if !found || mount.FSType != "nfs4" {
+		t.Errorf("findMount = %+v, %v; want the nfs4 mount", mount, found)
+	}
+}
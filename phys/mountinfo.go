@@ -0,0 +1,138 @@
+package phys
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// MountInfo describes a single mounted filesystem.
+type MountInfo struct {
+	MountPoint string   // Path the filesystem is mounted on, e.g. /media/user/My Passport
+	Source     string   // Mount source, usually a block device such as /dev/sdb1
+	FSType     string   // Filesystem type, e.g. ext4, exfat, ntfs3 (empty if unknown)
+	Options    []string // Per-mount options, e.g. rw, nosuid, relatime
+	ReadOnly   bool     // True if the filesystem is mounted read-only
+}
+
+const procMountInfoPath = "/proc/self/mountinfo"
+
+// pseudoFSTypes lists kernel filesystems that never hold user volumes.
+var pseudoFSTypes = map[string]struct{}{
+	"autofs": {}, "binfmt_misc": {}, "bpf": {}, "cgroup": {}, "cgroup2": {},
+	"configfs": {}, "debugfs": {}, "devpts": {}, "devtmpfs": {}, "efivarfs": {},
+	"fusectl": {}, "hugetlbfs": {}, "mqueue": {}, "nsfs": {}, "proc": {},
+	"pstore": {}, "rpc_pipefs": {}, "securityfs": {}, "selinuxfs": {},
+	"sysfs": {}, "tracefs": {},
+}
+
+// getLinuxMounts reads the mount table of the current process from /proc/self/mountinfo.
+func getLinuxMounts() ([]MountInfo, error) {
+	f, err := os.Open(procMountInfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open '%s': %w", procMountInfoPath, err)
+	}
+	defer f.Close()
+
+	mounts, err := parseMountInfo(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse '%s': %w", procMountInfoPath, err)
+	}
+	return mounts, nil
+}
+
+// parseMountInfo parses the mountinfo format described in proc(5):
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+//
+// Pseudo filesystems (proc, sysfs, cgroup, ...) are left out of the result.
+func parseMountInfo(r io.Reader) ([]MountInfo, error) {
+	var mounts []MountInfo
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		// The optional fields are terminated by a single "-"; everything after it
+		// is the filesystem type, the mount source and the super block options.
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 6 || sep < 0 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("malformed mountinfo line: %q", line)
+		}
+
+		options := strings.Split(fields[5], ",")
+		mount := MountInfo{
+			MountPoint: unescapeMountField(fields[4]),
+			Source:     unescapeMountField(fields[sep+2]),
+			FSType:     fields[sep+1],
+			Options:    options,
+			ReadOnly:   contains(options, "ro"),
+		}
+
+		if isPseudoMount(mount) {
+			continue
+		}
+		mounts = append(mounts, mount)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// unescapeMountField decodes the octal escapes (\040 for space, \011 for tab,
+// \012 for newline, \134 for backslash) the kernel uses in mountinfo fields.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctalDigits(s[i+1:i+4]) {
+			b.WriteByte((s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isOctalDigits reports whether s consists of exactly three octal digits.
+func isOctalDigits(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '7' {
+			return false
+		}
+	}
+	return true
+}
+
+// isPseudoMount reports whether a mount is a kernel pseudo filesystem or lives
+// under a kernel-managed tree, neither of which can contain rsdish volumes.
+func isPseudoMount(m MountInfo) bool {
+	if _, ok := pseudoFSTypes[m.FSType]; ok {
+		return true
+	}
+	for _, prefix := range []string{"/proc", "/sys", "/dev"} {
+		if m.MountPoint == prefix || strings.HasPrefix(m.MountPoint, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
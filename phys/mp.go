@@ -2,7 +2,9 @@ package phys
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -10,30 +12,46 @@ import (
 	"strings"
 )
 
-// GetMountPoints returns a list of all currently mounted paths for the current OS.
-func GetMountPoints() ([]string, error) {
+// GetMounts returns structured records for all currently mounted filesystems on the current OS.
+// Linux reads /proc/self/mountinfo natively; other Unixes fall back to parsing 'df' output.
+// Once ctx is done, 'df' is killed and drive letters that have not answered are skipped.
+func GetMounts(ctx context.Context) ([]MountInfo, error) {
 	switch runtime.GOOS {
-	case "darwin", "linux":
-		return getUnixMountPoints()
+	case "linux":
+		mounts, err := getLinuxMounts()
+		if err != nil {
+			log.Printf("Warning: %v. Falling back to 'df'.", err)
+			return getUnixMounts(ctx)
+		}
+		return mounts, nil
+	case "darwin", "freebsd", "openbsd", "netbsd":
+		return getUnixMounts(ctx)
 	case "windows":
-		return getWindowsMountPoints()
+		return getWindowsMounts(ctx)
 	default:
 		return nil, fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
 }
 
-// getUnixMountPoints fetches mount points on macOS and Linux using 'df -h'.
-func getUnixMountPoints() ([]string, error) {
-	cmd := exec.Command("df", "-h") // -h for human-readable, not strictly necessary for paths
+// getUnixMounts fetches mount points on Unix systems without /proc using 'df -h'.
+// df output is whitespace separated, so mount points containing spaces cannot be
+// recovered reliably; it is only used when no native backend is available.
+// df stats every filesystem, so a dead network share can block it; it is killed once
+// ctx is done.
+func getUnixMounts(ctx context.Context) ([]MountInfo, error) {
+	cmd := exec.CommandContext(ctx, "df", "-h") // -h for human-readable, not strictly necessary for paths
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("'df -h' did not finish: %w", ctx.Err())
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to run 'df -h': %w", err)
 	}
 
 	lines := strings.Split(out.String(), "\n")
-	var mountPoints []string
+	var mounts []MountInfo
 	// Skip header and empty lines
 	for i, line := range lines {
 		if i == 0 || strings.TrimSpace(line) == "" {
@@ -53,28 +71,58 @@ func getUnixMountPoints() ([]string, error) {
 			continue
 		}
 
-		mountPoints = append(mountPoints, mountPoint)
+		mounts = append(mounts, MountInfo{MountPoint: mountPoint, Source: fields[0]})
 	}
 
 	// Ensure root is always included if not caught by df output for some reason (unlikely but safe)
-	if !contains(mountPoints, "/") && (runtime.GOOS == "linux" || runtime.GOOS == "darwin") {
-		mountPoints = append(mountPoints, "/")
+	if !containsMount(mounts, "/") {
+		mounts = append(mounts, MountInfo{MountPoint: "/"})
 	}
 
-	return mountPoints, nil
+	return mounts, nil
 }
 
-// getWindowsMountPoints enumerates drive letters from A: to Z: directly.
-func getWindowsMountPoints() ([]string, error) {
-	var mountPoints []string
+// getWindowsMounts enumerates drive letters from A: to Z: directly. Each drive is
+// stat'ed in a goroutine of its own, since a disconnected network drive can block;
+// drives that have not answered when ctx is done are skipped.
+func getWindowsMounts(ctx context.Context) ([]MountInfo, error) {
+	type driveResult struct {
+		drive  string
+		exists bool
+	}
+	results := make(chan driveResult, 26) // Buffered, so that abandoned goroutines can finish
+	for c := 'A'; c <= 'Z'; c++ {
+		go func(drive string) {
+			// 判断盘符是否存在（即路径存在）
+			_, err := os.Stat(drive)
+			results <- driveResult{drive: drive, exists: err == nil}
+		}(string(c) + ":\\")
+	}
+
+	answered := make(map[string]bool, 26)
+wait:
+	for len(answered) < 26 {
+		select {
+		case result := <-results:
+			answered[result.drive] = result.exists
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	var mounts []MountInfo
 	for c := 'A'; c <= 'Z'; c++ {
 		drive := string(c) + ":\\"
-		// 判断盘符是否存在（即路径存在）
-		if _, err := os.Stat(drive); err == nil {
-			mountPoints = append(mountPoints, filepath.Clean(drive)+"\\")
+		exists, ok := answered[drive]
+		if !ok {
+			log.Printf("Warning: Drive %s did not respond, skipping it. It may be a disconnected network drive.", drive)
+			continue
+		}
+		if exists {
+			mounts = append(mounts, MountInfo{MountPoint: filepath.Clean(drive) + "\\", Source: drive})
 		}
 	}
-	return mountPoints, nil
+	return mounts, nil
 }
 
 // contains is a helper to check if a string is in a slice.
@@ -86,3 +134,13 @@ func contains(s []string, e string) bool {
 	}
 	return false
 }
+
+// containsMount is a helper to check if a mount point is already present in a slice of mounts.
+func containsMount(mounts []MountInfo, mountPoint string) bool {
+	for _, m := range mounts {
+		if m.MountPoint == mountPoint {
+			return true
+		}
+	}
+	return false
+}
@@ -1,132 +1,376 @@
 package phys
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"sync"
+	"time"
 
 	"rsdish/persist"
 
 	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
 )
 
-// PhysTree maps a volume's mount point path (e.g., /mnt/my-drive/volumes/volumeA)
-// to its parsed VolumeConfig.
-var (
-	PhysTree = make(map[string]*persist.VolumeConfig)
-	mu       sync.Mutex
-)
-
-// BuildPhysTree orchestrates the discovery and loading of all configured volumes.
-func BuildPhysTree() {
-	mu.Lock()
-	PhysTree = make(map[string]*persist.VolumeConfig) // Re-initialize the map
-	mu.Unlock()
+// Scan discovers and loads all volumes reachable through the scanner's mount points
+// and returns them as a new Snapshot.
+func (s *Scanner) Scan() *Snapshot {
+	snapshot := &Snapshot{
+		Volumes: make(map[string]*persist.VolumeConfig),
+		Aliases: make(map[string][]string),
+		FSInfo:  make(map[string]*FSCaps),
+	}
 
-	mps, err := getAllMountpointsIncludeAdditionals()
+	systemMounts, err := s.ListMounts()
 	if err != nil {
 		log.Printf("Failed to get mountpoints: %v", err)
-		return
+		return snapshot
 	}
+	snapshot.Mounts = systemMounts
+	mps := s.mountpointsIncludeAdditionals(systemMounts)
 
-	var wg sync.WaitGroup
+	type mountResult struct {
+		volumes map[string]*persist.VolumeConfig
+		err     error
+	}
+	results, timedOut := collectWithin(mps, s.Timeout, func(mp string) mountResult {
+		volumes, err := s.LoadTomlFromMountpoint(mp)
+		return mountResult{volumes: volumes, err: err}
+	})
 	for _, mp := range mps {
-		wg.Add(1)
-		go func(mountpoint string) {
-			defer wg.Done()
-			if err := LoadTomlFromMountpoint(mountpoint); err != nil {
-				log.Printf("Failed to process TOML from mountpoint %s: %v", mountpoint, err)
+		result, ok := results[mp]
+		if !ok {
+			continue
+		}
+		if result.err != nil {
+			log.Printf("Failed to process TOML from mountpoint %s: %v", mp, result.err)
+		}
+		for basePath, volumeCfg := range result.volumes {
+			snapshot.Volumes[basePath] = volumeCfg
+		}
+	}
+	for _, mp := range timedOut {
+		log.Printf("Warning: Mount point '%s' did not respond within %s, skipping it. It may be a dead network share or a failing drive; volumes on it are treated as disconnected.", mp, s.Timeout)
+	}
+	snapshot.TimedOut = timedOut
+
+	s.dedupeVolumes(snapshot)
+	var prober FSProber
+	if s.ProbeFS {
+		prober, _ = s.FS.(FSProber)
+	}
+	s.probeVolumes(snapshot, prober)
+	sort.Strings(snapshot.TimedOut)
+	return snapshot
+}
+
+// collectWithin calls fn for every key in a goroutine of its own and returns the results
+// of the calls that returned within timeout (0 waits forever), together with the keys,
+// sorted, whose call did not. A call stuck on a dead mount cannot be interrupted, so it
+// is abandoned; the results channel is buffered so that it can still finish later
+// without blocking. All calls start at the same time, so one timer is the deadline of each.
+func collectWithin[T any](keys []string, timeout time.Duration, fn func(key string) T) (map[string]T, []string) {
+	type result struct {
+		key   string
+		value T
+	}
+	results := make(chan result, len(keys))
+	pending := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		pending[key] = struct{}{}
+		go func(key string) {
+			results <- result{key: key, value: fn(key)}
+		}(key)
+	}
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	values := make(map[string]T, len(keys))
+	for len(pending) > 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.key)
+			values[r.key] = r.value
+		case <-deadline:
+			late := make([]string, 0, len(pending))
+			for key := range pending {
+				late = append(late, key)
 			}
-		}(mp)
+			sort.Strings(late)
+			return values, late
+		}
 	}
-	wg.Wait()
+	return values, nil
 }
 
-// getAllMountpointsIncludeAdditionals combines system mount points with user-defined
-// additional mount points from ~/.rsdish and returns a unique list of paths.
-func getAllMountpointsIncludeAdditionals() ([]string, error) {
-	uniqueMounts := make(map[string]struct{})
+// dropTimedOutVolumes removes volumes that stopped answering after they were found
+// from the snapshot and records them in TimedOut.
+func (s *Scanner) dropTimedOutVolumes(snapshot *Snapshot, basePaths []string, step string) {
+	for _, basePath := range basePaths {
+		log.Printf("Warning: Volume '%s' did not respond within %s while %s, skipping it. Its drive may be failing; the volume is treated as disconnected.", basePath, s.Timeout, step)
+		delete(snapshot.Volumes, basePath)
+		delete(snapshot.Aliases, basePath)
+		snapshot.TimedOut = append(snapshot.TimedOut, basePath)
+	}
+}
 
-	systemMps, err := GetMountPoints()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get system mount points: %w", err)
+// probeVolumes records the filesystem capabilities of every volume in the snapshot
+// and downgrades link_create modes the filesystem cannot honor. Capabilities are
+// measured through prober, or only inferred from the mount table if it is nil.
+func (s *Scanner) probeVolumes(snapshot *Snapshot, prober FSProber) {
+	basePaths := make([]string, 0, len(snapshot.Volumes))
+	for basePath := range snapshot.Volumes {
+		basePaths = append(basePaths, basePath)
 	}
-	for _, mp := range systemMps {
-		uniqueMounts[mp] = struct{}{}
+	caps, timedOut := collectWithin(basePaths, s.Timeout, func(basePath string) *FSCaps {
+		return probeVolume(basePath, snapshot.Mounts, prober)
+	})
+	s.dropTimedOutVolumes(snapshot, timedOut, "probing its filesystem")
+
+	for basePath, volumeCfg := range snapshot.Volumes {
+		snapshot.FSInfo[basePath] = caps[basePath]
+		adjustLinkCreate(volumeCfg, caps[basePath], basePath)
 	}
+}
 
-	cfg, err := persist.LoadConfig()
-	if err != nil {
-		log.Printf("Warning: Failed to load user config for additional mount points: %v", err)
-	} else {
-		for _, amp := range cfg.AdditionalMountpoints {
-			uniqueMounts[amp] = struct{}{}
+// dedupeVolumes collapses snapshot entries that refer to the same volume directory,
+// which happens when a drive is reachable through a bind mount or listed twice in
+// the mount table. Identity is resolved by device/inode (os.SameFile); when a path
+// cannot be stat'ed, the per-volume ID is used instead. The shortest path is kept
+// and the merged paths are recorded in Aliases.
+func (s *Scanner) dedupeVolumes(snapshot *Snapshot) {
+	type statResult struct {
+		info fs.FileInfo
+		err  error
+	}
+	allPaths := make([]string, 0, len(snapshot.Volumes))
+	for p := range snapshot.Volumes {
+		allPaths = append(allPaths, p)
+	}
+	stats, timedOut := collectWithin(allPaths, s.Timeout, func(p string) statResult {
+		info, err := s.FS.Stat(p)
+		return statResult{info: info, err: err}
+	})
+	s.dropTimedOutVolumes(snapshot, timedOut, "resolving its identity")
+
+	paths := make([]string, 0, len(snapshot.Volumes))
+	for p := range snapshot.Volumes {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if len(paths[i]) != len(paths[j]) {
+			return len(paths[i]) < len(paths[j])
+		}
+		return paths[i] < paths[j]
+	})
+
+	infos := make(map[string]fs.FileInfo, len(paths))
+	for _, p := range paths {
+		if err := stats[p].err; err != nil {
+			log.Printf("Warning: Could not stat volume '%s' to resolve its identity: %v", p, err)
+			continue
+		}
+		infos[p] = stats[p].info
+	}
+
+	var kept []string
+	for _, p := range paths {
+		canonical := ""
+		for _, k := range kept {
+			if sameVolume(snapshot.Volumes, p, k, infos) {
+				canonical = k
+				break
+			}
 		}
+
+		if canonical == "" {
+			kept = append(kept, p)
+			continue
+		}
+
+		snapshot.Aliases[canonical] = append(snapshot.Aliases[canonical], p)
+		delete(snapshot.Volumes, p)
+		log.Printf("Merged duplicate volume '%s' into '%s' (same volume seen twice).", p, canonical)
 	}
+}
 
+// sameVolume reports whether two discovered volume paths refer to the same volume.
+func sameVolume(volumes map[string]*persist.VolumeConfig, a, b string, infos map[string]fs.FileInfo) bool {
+	infoA, okA := infos[a]
+	infoB, okB := infos[b]
+	idA, idB := volumes[a].Volume.ID, volumes[b].Volume.ID
+
+	if okA && okB {
+		if os.SameFile(infoA, infoB) {
+			return true
+		}
+		if idA != "" && idA == idB {
+			log.Printf("Warning: Volumes '%s' and '%s' share volume ID '%s' but are different directories. Was volume.toml copied? Run 'rsdish template id' after removing the 'id' line from one of them.", a, b, idA)
+		}
+		return false
+	}
+
+	// Without device/inode identity, fall back to the per-volume ID.
+	return idA != "" && idA == idB
+}
+
+// mountpointsIncludeAdditionals combines system mount points with the additional
+// mount points of the scanner's config and returns the unique paths that pass the
+// mount filters, see MountCandidates.
+func (s *Scanner) mountpointsIncludeAdditionals(systemMounts []MountInfo) []string {
 	var result []string
-	for mp := range uniqueMounts {
-		result = append(result, mp)
+	for _, candidate := range s.MountCandidates(systemMounts) {
+		if candidate.SkipReason != "" {
+			continue
+		}
+		result = append(result, candidate.MountPoint)
+	}
+	return result
+}
+
+// defaultVolumeRoot is the folder searched for volumes on every mount point unless
+// the user config sets volume_roots.
+const defaultVolumeRoot = "volumes"
+
+// volumeRoots returns the folders, relative to a mount point, that are searched for volumes.
+func (s *Scanner) volumeRoots() []string {
+	if s.Config == nil || len(s.Config.VolumeRoots) == 0 {
+		return []string{defaultVolumeRoot}
 	}
+	var roots []string
+	for _, root := range s.Config.VolumeRoots {
+		if !filepath.IsLocal(root) {
+			log.Printf("Warning: Ignoring volume_roots entry '%s' in user config: it must be a relative path inside the mount point.", root)
+			continue
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
 
-	return result, nil
+// searchDepth returns how many folder levels below a volume root are searched,
+// or a negative number for no limit.
+func (s *Scanner) searchDepth() int {
+	if s.Config == nil || s.Config.VolumeSearchDepth == 0 {
+		return 1
+	}
+	return s.Config.VolumeSearchDepth
 }
 
-// LoadTomlFromMountpoint now walks the 'volumes' subdirectory within the given mountpoint
-// to find and load 'volume.toml' files.
-func LoadTomlFromMountpoint(mp string) error {
-	volumesDirPath := filepath.Join(mp, "volumes")
+// LoadTomlFromMountpoint returns the valid 'volume.toml' files of the given mountpoint,
+// keyed by their directory. A volume.toml at the mountpoint itself makes the whole
+// drive a single volume; otherwise each volume root folder (by default 'volumes')
+// is searched down to the search depth.
+func (s *Scanner) LoadTomlFromMountpoint(mp string) (map[string]*persist.VolumeConfig, error) {
+	volumes := make(map[string]*persist.VolumeConfig)
 
-	if _, err := os.Stat(volumesDirPath); os.IsNotExist(err) {
+	// The volume roots of a drive that is a volume itself are just folders of that
+	// volume; volumes nested in them would be copied twice.
+	rootConfigPath := filepath.Join(mp, persist.VolumeConfigFileName)
+	info, err := s.FS.Stat(rootConfigPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to stat '%s': %v", rootConfigPath, err)
+	}
+	if err == nil && info.Mode().IsRegular() {
+		s.loadVolumeConfig(rootConfigPath, volumes)
+		for _, root := range s.volumeRoots() {
+			if _, err := s.FS.Stat(filepath.Join(mp, root)); err == nil {
+				log.Printf("Warning: Not searching '%s' for volumes: '%s' makes the whole drive one volume.", filepath.Join(mp, root), rootConfigPath)
+			}
+		}
+		return volumes, nil
+	}
+
+	var errs []error
+	for _, root := range s.volumeRoots() {
+		if err := s.searchVolumeRoot(filepath.Join(mp, root), volumes); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return volumes, errors.Join(errs...)
+}
+
+// searchVolumeRoot walks a volume root folder down to the search depth and adds the
+// volume.toml files found there to volumes. Folders below the depth, i.e. the
+// contents of the volumes themselves, are never read.
+func (s *Scanner) searchVolumeRoot(rootPath string, volumes map[string]*persist.VolumeConfig) error {
+	if _, err := s.FS.Stat(rootPath); errors.Is(err, fs.ErrNotExist) {
 		return nil
 	} else if err != nil {
-		return fmt.Errorf("failed to stat 'volumes' directory at '%s': %w", volumesDirPath, err)
+		return fmt.Errorf("failed to stat volume root '%s': %w", rootPath, err)
 	}
 
-	err := fs.WalkDir(os.DirFS(volumesDirPath), ".", func(path string, d fs.DirEntry, err error) error {
+	maxDepth := s.searchDepth()
+	err := s.FS.WalkDir(rootPath, func(fullPath string, d fs.DirEntry, err error) error {
 		if err != nil {
-			log.Printf("Error walking path %s within %s/volumes: %v", path, mp, err)
+			log.Printf("Error walking path %s within %s: %v", fullPath, rootPath, err)
 			return nil
 		}
 
-		if d.Type().IsRegular() && strings.ToLower(d.Name()) == "volume.toml" {
-			fullTomlPath := filepath.Join(volumesDirPath, path)
-
-			var volumeCfg persist.VolumeConfig
-			_, decodeErr := toml.DecodeFile(fullTomlPath, &volumeCfg)
-			if decodeErr != nil {
-				log.Printf("Failed to decode TOML file '%s': %v", fullTomlPath, decodeErr)
-				return nil
+		if d.IsDir() {
+			if maxDepth >= 0 && pathDepth(rootPath, fullPath) > maxDepth {
+				return fs.SkipDir
 			}
-
-			// Validate the loaded volume configuration
-			if validationErr := validateVolumeConfig(&volumeCfg); validationErr != nil {
-				log.Printf("Validation failed for '%s': %v", fullTomlPath, validationErr)
-				return nil
-			}
-
-			volumeBasePath := filepath.Dir(fullTomlPath)
-
-			mu.Lock()
-			PhysTree[volumeBasePath] = &volumeCfg
-			mu.Unlock()
-
-			log.Printf("Successfully loaded volume from %s", volumeBasePath)
+			return nil
+		}
+		if d.Type().IsRegular() && strings.ToLower(d.Name()) == persist.VolumeConfigFileName {
+			s.loadVolumeConfig(fullPath, volumes)
 		}
 		return nil
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to walk 'volumes' directory in '%s': %w", mp, err)
+		return fmt.Errorf("failed to walk volume root '%s': %w", rootPath, err)
 	}
-
 	return nil
 }
 
+// pathDepth returns how many folder levels fullPath lies below root; 0 for root itself.
+func pathDepth(root, fullPath string) int {
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// loadVolumeConfig reads, decodes and validates the volume.toml at configPath and adds
+// it to volumes under its directory. Invalid files are logged and skipped.
+func (s *Scanner) loadVolumeConfig(configPath string, volumes map[string]*persist.VolumeConfig) {
+	data, err := s.FS.ReadFile(configPath)
+	if err != nil {
+		log.Printf("Failed to read TOML file '%s': %v", configPath, err)
+		return
+	}
+
+	var volumeCfg persist.VolumeConfig
+	if _, err := toml.Decode(string(data), &volumeCfg); err != nil {
+		log.Printf("Failed to decode TOML file '%s': %v", configPath, err)
+		return
+	}
+
+	// Validate the loaded volume configuration
+	if err := validateVolumeConfig(&volumeCfg); err != nil {
+		log.Printf("Validation failed for '%s': %v", configPath, err)
+		return
+	}
+
+	volumeBasePath := filepath.Dir(configPath)
+	volumes[volumeBasePath] = &volumeCfg
+
+	log.Printf("Successfully loaded volume from %s", volumeBasePath)
+}
+
 // validateVolumeConfig checks if the loaded VolumeConfig meets required criteria.
 // It enforces that 'library.uuid' and 'volume.mode' must be present and valid.
 // Other fields like 'note', 'rclone_arguments', and 'link_creat' are optional.
@@ -138,21 +382,35 @@ func validateVolumeConfig(cfg *persist.VolumeConfig) error {
 
 	// 2. Validate 'volume.mode' (Required and must be specific values)
 	switch cfg.Volume.Mode {
-	case "storage", "buffer":
+	case "storage", "buffer", "partial":
 		// Valid modes
 	case "":
 		return fmt.Errorf("volume config missing required 'volume.mode'")
 	default:
-		return fmt.Errorf("volume config has invalid 'volume.mode': '%s'. Must be 'storage' or 'buffer'", cfg.Volume.Mode)
+		return fmt.Errorf("volume config has invalid 'volume.mode': '%s'. Must be 'storage', 'buffer' or 'partial'", cfg.Volume.Mode)
+	}
+
+	// 3. Validate 'volume.budget' (Optional, only meaningful for partial volumes)
+	if _, err := persist.ParseSize(cfg.Volume.Budget); err != nil {
+		return fmt.Errorf("volume config has invalid 'volume.budget': %w", err)
 	}
 
-	// 3. Validate 'volume.note' (Optional)
+	// 4. Validate 'volume.id' (Optional for legacy volumes, but if present, must be a UUID)
+	if cfg.Volume.ID != "" {
+		if _, err := uuid.Parse(cfg.Volume.ID); err != nil {
+			return fmt.Errorf("volume config has invalid 'volume.id': '%s'. Must be a UUID", cfg.Volume.ID)
+		}
+	}
+
+	// 5. Validate 'volume.note' (Optional)
 	// No specific validation needed as it's 'ANY' and omitempty.
 
-	// 4. Validate 'advanced.rclone_arguments' (Optional)
-	// No specific validation needed as it's an example string and optional.
+	// 6. Validate 'advanced.rclone_arguments' (Optional, but if present, its quotes must balance)
+	if _, err := persist.SplitArguments(cfg.Advanced.RcloneArguments); err != nil {
+		return fmt.Errorf("volume config has invalid 'advanced.rclone_arguments': %w", err)
+	}
 
-	// 5. Validate 'advanced.link_creat' (Optional, but if present, must be specific values)
+	// 7. Validate 'advanced.link_creat' (Optional, but if present, must be specific values)
 	if cfg.Advanced.LinkCreate != "" { // Only validate if the field is present/not empty
 		switch cfg.Advanced.LinkCreate {
 		case "none", "symlink", "cheatfile":
@@ -163,5 +421,20 @@ func validateVolumeConfig(cfg *persist.VolumeConfig) error {
 	}
 	// If cfg.Advanced.LinkCreat is empty, it's considered valid because it's optional.
 
+	// 8. Validate 'library.conflict_policy' (Optional, but if present, must be specific values)
+	if cfg.Library.ConflictPolicy != "" && !persist.IsConflictPolicy(cfg.Library.ConflictPolicy) {
+		return fmt.Errorf("volume config has invalid 'library.conflict_policy': '%s'. Must be 'skip', 'newest', 'largest', or 'keep-both'", cfg.Library.ConflictPolicy)
+	}
+
+	// 9. Validate 'library.min_copies' (Optional, but if present, must not be negative)
+	if cfg.Library.MinCopies < 0 {
+		return fmt.Errorf("volume config has invalid 'library.min_copies': %d. Must be 0 or more", cfg.Library.MinCopies)
+	}
+
+	// 10. Validate the [filter] globs (Optional)
+	if _, err := persist.CompileFilter(cfg.Filter); err != nil {
+		return fmt.Errorf("volume config has an invalid [filter] section: %w", err)
+	}
+
 	return nil
 }
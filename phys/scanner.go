@@ -0,0 +1,124 @@
+package phys
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"rsdish/persist"
+)
+
+// MountSource lists the mounted filesystems to search for volumes. Mounts must return
+// once ctx is done, even if some filesystems have not answered.
+type MountSource interface {
+	Mounts(ctx context.Context) ([]MountInfo, error)
+}
+
+// SystemMounts is the MountSource of the running system, see GetMounts.
+type SystemMounts struct{}
+
+// Mounts returns the mount table of the running system.
+func (SystemMounts) Mounts(ctx context.Context) ([]MountInfo, error) {
+	return GetMounts(ctx)
+}
+
+// StaticMounts is a fixed list of mounts, e.g. plain directories standing in for drives.
+type StaticMounts []MountInfo
+
+// Mounts returns the list itself.
+func (m StaticMounts) Mounts(ctx context.Context) ([]MountInfo, error) {
+	return m, nil
+}
+
+// FileSystem is the part of the filesystem that volume discovery reads. The same
+// filesystem is used afterwards to read the files inside the volumes.
+type FileSystem interface {
+	persist.FileSystem
+}
+
+// OSFileSystem is the FileSystem of the operating system.
+type OSFileSystem struct {
+	persist.OSFileSystem
+}
+
+// ProbeFSCaps measures the capabilities of the filesystem holding dir, see FSProber.
+func (OSFileSystem) ProbeFSCaps(dir string, caps *FSCaps) error { return probeFSCaps(dir, caps) }
+
+// FSProber is implemented by a FileSystem that can measure the capabilities of the
+// filesystem holding a directory by writing scratch files into it. Scan probes only
+// through the scanner's FS, so a FileSystem without it is never written to.
+type FSProber interface {
+	ProbeFSCaps(dir string, caps *FSCaps) error
+}
+
+// DefaultMountTimeout is how long discovery waits for a mount point unless the user
+// config sets mount_timeout.
+const DefaultMountTimeout = 30 * time.Second
+
+// Scanner discovers the volumes on a set of mounts. Every field can be replaced,
+// so that discovery can run against temporary directories instead of the host.
+type Scanner struct {
+	Config *persist.Config // User config providing additional mount points (may be nil)
+	Mounts MountSource
+	FS     FileSystem
+
+	// Timeout is how long Scan waits for each step that touches the filesystems:
+	// listing the mounts, reading the volumes of each mount point, and resolving
+	// and probing each volume. Whatever has not answered by then, e.g. a dead network
+	// share or a failing drive, is given up on. 0 waits forever.
+	Timeout time.Duration
+
+	// ProbeFS makes Scan measure the capabilities of every writable volume's filesystem
+	// by writing scratch files into it, if FS is an FSProber. Without it they are inferred
+	// from the mount table, so that commands which do not create links never write into
+	// the volumes.
+	ProbeFS bool
+}
+
+// NewScanner returns a Scanner for the mounts and filesystem of the running system.
+func NewScanner(cfg *persist.Config) *Scanner {
+	return &Scanner{Config: cfg, Mounts: SystemMounts{}, FS: OSFileSystem{}, Timeout: mountTimeout(cfg)}
+}
+
+// ListMounts returns the mounts of the scanner's MountSource, giving up after Timeout.
+func (s *Scanner) ListMounts() ([]MountInfo, error) {
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	mounts, err := s.Mounts.Mounts(ctx)
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("listing the mounts did not finish within %s: %w", s.Timeout, err)
+	}
+	return mounts, err
+}
+
+// mountTimeout returns the mount_timeout of the user config, or DefaultMountTimeout
+// if it is not set or invalid.
+func mountTimeout(cfg *persist.Config) time.Duration {
+	if cfg == nil || cfg.MountTimeout == "" {
+		return DefaultMountTimeout
+	}
+	timeout, err := time.ParseDuration(cfg.MountTimeout)
+	if err != nil || timeout < 0 {
+		log.Printf("Warning: Invalid mount_timeout '%s' in user config, using %s. Use a duration such as \"30s\".", cfg.MountTimeout, DefaultMountTimeout)
+		return DefaultMountTimeout
+	}
+	return timeout
+}
+
+// Snapshot is the result of one Scan. It is not changed after Scan returns.
+type Snapshot struct {
+	Volumes map[string]*persist.VolumeConfig // Volume directory (e.g. /mnt/my-drive/volumes/volumeA) to its volume.toml
+	Aliases map[string][]string              // Volume directory to the other paths the same directory was discovered under
+	FSInfo  map[string]*FSCaps               // Volume directory to the capabilities of its filesystem
+	Mounts  []MountInfo                      // Mount table the volumes were discovered on
+
+	// TimedOut lists the mount points and volume directories, sorted, that did not
+	// answer within the scanner's Timeout. Volumes on or at them are missing from
+	// the snapshot.
+	TimedOut []string
+}
@@ -0,0 +1,208 @@
+package phys
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rsdish/persist"
+)
+
+// writeVolumeConfig creates dir and writes a volume.toml with the given content into it.
+func writeVolumeConfig(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "volume.toml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const (
+	testLibrary = "11111111-1111-1111-1111-111111111111"
+	testVolumeA = "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"
+	testVolumeB = "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb"
+)
+
+func volumeToml(id, mode string) string {
+	return "[library]\nuuid = \"" + testLibrary + "\"\n\n[volume]\nid = \"" + id + "\"\nmode = \"" + mode + "\"\n"
+}
+
+// tempScanner returns a Scanner searching the given directories as if they were mount points.
+func tempScanner(mountPoints ...string) *Scanner {
+	var mounts StaticMounts
+	for _, mp := range mountPoints {
+		mounts = append(mounts, MountInfo{MountPoint: mp, FSType: "ext4"})
+	}
+	return &Scanner{Mounts: mounts, FS: OSFileSystem{}}
+}
+
+func TestScanDiscoversVolumes(t *testing.T) {
+	tmp := t.TempDir()
+	disk1, disk2 := filepath.Join(tmp, "disk1"), filepath.Join(tmp, "disk2")
+	writeVolumeConfig(t, filepath.Join(disk1, "volumes", "movies"), volumeToml(testVolumeA, "storage"))
+	writeVolumeConfig(t, disk2, volumeToml(testVolumeB, "buffer"))
+	// Below the default search depth, and invalid: neither is discovered
+	writeVolumeConfig(t, filepath.Join(disk1, "volumes", "movies", "nested"), volumeToml("", "storage"))
+	writeVolumeConfig(t, filepath.Join(disk2, "volumes", "broken"), "[library]\nuuid = \""+testLibrary+"\"\n")
+
+	snapshot := tempScanner(disk1, disk2).Scan()
+
+	want := []string{filepath.Join(disk1, "volumes", "movies"), disk2}
+	if len(snapshot.Volumes) != len(want) {
+		t.Fatalf("discovered %d volumes, want %d: %v", len(snapshot.Volumes), len(want), snapshot.Volumes)
+	}
+	for _, basePath := range want {
+		if _, ok := snapshot.Volumes[basePath]; !ok {
+			t.Errorf("volume '%s' not discovered", basePath)
+		}
+	}
+	if got := snapshot.Volumes[disk2].Volume.Mode; got != "buffer" {
+		t.Errorf("mode of '%s' = %q, want buffer", disk2, got)
+	}
+}
+
+func TestScanSearchesConfiguredDepth(t *testing.T) {
+	tmp := t.TempDir()
+	nested := filepath.Join(tmp, "volumes", "2025", "movies")
+	writeVolumeConfig(t, nested, volumeToml(testVolumeA, "storage"))
+
+	scanner := tempScanner(tmp)
+	if snapshot := scanner.Scan(); len(snapshot.Volumes) != 0 {
+		t.Errorf("default depth discovered %v, want nothing", snapshot.Volumes)
+	}
+
+	scanner.Config = &persist.Config{VolumeSearchDepth: 2}
+	if snapshot := scanner.Scan(); snapshot.Volumes[nested] == nil {
+		t.Errorf("depth 2 did not discover '%s'", nested)
+	}
+}
+
+func TestScanMergesVolumeSeenTwice(t *testing.T) {
+	tmp := t.TempDir()
+	disk := filepath.Join(tmp, "disk")
+	writeVolumeConfig(t, disk, volumeToml(testVolumeA, "storage"))
+	// A second path to the same directory, as a bind mount would give
+	alias := filepath.Join(tmp, "disk-alias")
+	if err := os.Symlink(disk, alias); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+
+	snapshot := tempScanner(disk, alias).Scan()
+
+	if len(snapshot.Volumes) != 1 || snapshot.Volumes[disk] == nil {
+		t.Fatalf("volumes = %v, want only '%s'", snapshot.Volumes, disk)
+	}
+	if aliases := snapshot.Aliases[disk]; len(aliases) != 1 || aliases[0] != alias {
+		t.Errorf("aliases of '%s' = %v, want [%s]", disk, aliases, alias)
+	}
+}
+
+func TestScanKeepsCopiedVolumeIDsApart(t *testing.T) {
+	tmp := t.TempDir()
+	disk1, disk2 := filepath.Join(tmp, "disk1"), filepath.Join(tmp, "disk2")
+	// volume.toml copied from one drive to another: same ID, different directories
+	writeVolumeConfig(t, disk1, volumeToml(testVolumeA, "storage"))
+	writeVolumeConfig(t, disk2, volumeToml(testVolumeA, "storage"))
+
+	snapshot := tempScanner(disk1, disk2).Scan()
+
+	if len(snapshot.Volumes) != 2 {
+		t.Errorf("volumes = %v, want both '%s' and '%s'", snapshot.Volumes, disk1, disk2)
+	}
+}
+
+func TestScanProbesOnlyWhenAsked(t *testing.T) {
+	tmp := t.TempDir()
+	writeVolumeConfig(t, tmp, volumeToml(testVolumeA, "storage"))
+
+	scanner := tempScanner(tmp)
+	if caps := scanner.Scan().FSInfo[tmp]; caps == nil || caps.Probed {
+		t.Errorf("capabilities without ProbeFS = %+v, want inferred ones", caps)
+	}
+
+	scanner.ProbeFS = true
+	if caps := scanner.Scan().FSInfo[tmp]; caps == nil || !caps.Probed {
+		t.Errorf("capabilities with ProbeFS = %+v, want probed ones", caps)
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), persist.ProbeDirPrefix) {
+			t.Errorf("probe directory '%s' left behind", entry.Name())
+		}
+	}
+}
+
+// blockingMounts never answers before ctx is done, like 'df' stuck on a dead share.
+type blockingMounts struct{}
+
+func (blockingMounts) Mounts(ctx context.Context) ([]MountInfo, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// blockingFS is the operating system's filesystem, except that stat'ing blocked hangs
+// until the test ends, like a volume on a failing drive.
+type blockingFS struct {
+	OSFileSystem
+	blocked string
+	release chan struct{}
+}
+
+func (f blockingFS) Stat(name string) (fs.FileInfo, error) {
+	if name == f.blocked {
+		<-f.release
+	}
+	return f.OSFileSystem.Stat(name)
+}
+
+func TestScanTimesOutListingMounts(t *testing.T) {
+	scanner := &Scanner{Mounts: blockingMounts{}, FS: OSFileSystem{}, Timeout: 10 * time.Millisecond}
+
+	snapshot := scanner.Scan()
+
+	if len(snapshot.Volumes) != 0 {
+		t.Errorf("volumes = %v, want none", snapshot.Volumes)
+	}
+}
+
+func TestScanSkipsVolumeThatStopsAnswering(t *testing.T) {
+	tmp := t.TempDir()
+	disk1, disk2 := filepath.Join(tmp, "disk1"), filepath.Join(tmp, "disk2")
+	writeVolumeConfig(t, disk1, volumeToml(testVolumeA, "storage"))
+	writeVolumeConfig(t, disk2, volumeToml(testVolumeB, "storage"))
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	scanner := tempScanner(disk1, disk2)
+	scanner.FS = blockingFS{blocked: disk2, release: release}
+	scanner.Timeout = 50 * time.Millisecond
+	snapshot := scanner.Scan()
+
+	if len(snapshot.Volumes) != 1 || snapshot.Volumes[disk1] == nil {
+		t.Errorf("volumes = %v, want only '%s'", snapshot.Volumes, disk1)
+	}
+	if len(snapshot.TimedOut) != 1 || snapshot.TimedOut[0] != disk2 {
+		t.Errorf("timed out = %v, want [%s]", snapshot.TimedOut, disk2)
+	}
+}
+
+func TestScanTreatsDriveWithRootVolumeAsOneVolume(t *testing.T) {
+	tmp := t.TempDir()
+	writeVolumeConfig(t, tmp, volumeToml(testVolumeA, "storage"))
+	writeVolumeConfig(t, filepath.Join(tmp, "volumes", "movies"), volumeToml(testVolumeB, "storage"))
+
+	snapshot := tempScanner(tmp).Scan()
+
+	if len(snapshot.Volumes) != 1 || snapshot.Volumes[tmp] == nil {
+		t.Errorf("volumes = %v, want only the drive '%s'", snapshot.Volumes, tmp)
+	}
+}
@@ -0,0 +1,37 @@
+package phys
+
+import "fmt"
+
+// DiskUsage reports the size of the filesystem holding a path, in bytes.
+type DiskUsage struct {
+	Total uint64 // Capacity of the filesystem
+	Used  uint64 // Bytes in use
+	Free  uint64 // Bytes available to the current (unprivileged) user
+
+	// Device identifies the filesystem; paths with the same Device share the capacity
+	// above. Empty if it cannot be determined.
+	Device string
+}
+
+// GetDiskUsage returns capacity, used and free bytes of the filesystem holding path.
+func GetDiskUsage(path string) (DiskUsage, error) {
+	usage, err := diskUsage(path)
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("failed to get disk usage of '%s': %w", path, err)
+	}
+	return usage, nil
+}
+
+// FormatBytes renders a byte count with binary units, e.g. "1.5 GiB".
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
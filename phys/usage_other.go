@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+
+package phys
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// diskUsage is not implemented on this platform.
+func diskUsage(path string) (DiskUsage, error) {
+	return DiskUsage{}, fmt.Errorf("disk usage is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin
+
+package phys
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// diskUsage queries statfs for the filesystem holding path.
+func diskUsage(path string) (DiskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return DiskUsage{}, err
+	}
+
+	blockSize := uint64(st.Bsize)
+	usage := DiskUsage{
+		Total: st.Blocks * blockSize,
+		Used:  (st.Blocks - st.Bfree) * blockSize,
+		Free:  st.Bavail * blockSize,
+	}
+
+	var fileSt syscall.Stat_t
+	if err := syscall.Stat(path, &fileSt); err == nil {
+		usage.Device = strconv.FormatUint(uint64(fileSt.Dev), 10)
+	}
+	return usage, nil
+}
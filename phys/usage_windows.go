@@ -0,0 +1,53 @@
+package phys
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceExW = kernel32.NewProc("GetDiskFreeSpaceExW")
+	procGetVolumePathNameW  = kernel32.NewProc("GetVolumePathNameW")
+)
+
+// diskUsage queries GetDiskFreeSpaceExW for the volume holding path.
+func diskUsage(path string) (DiskUsage, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+
+	var freeToCaller, total, totalFree uint64
+	ret, _, callErr := procGetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&freeToCaller)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&totalFree)),
+	)
+	if ret == 0 {
+		return DiskUsage{}, callErr
+	}
+
+	return DiskUsage{
+		Total:  total,
+		Used:   total - totalFree,
+		Free:   freeToCaller,
+		Device: volumePathName(pathPtr),
+	}, nil
+}
+
+// volumePathName returns the root of the volume holding path, e.g. "E:\", or "" if unknown.
+func volumePathName(pathPtr *uint16) string {
+	buf := make([]uint16, syscall.MAX_PATH+1)
+	ret, _, _ := procGetVolumePathNameW.Call(
+		uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(len(buf)),
+	)
+	if ret == 0 {
+		return ""
+	}
+	return strings.ToUpper(syscall.UTF16ToString(buf))
+}